@@ -0,0 +1,30 @@
+package clock
+
+import "time"
+
+// Clock は現在時刻とタイマーを提供する
+// 本番では Real を使い、テストでは Fake で時間を手動で進める
+type Clock interface {
+	Now() time.Time
+	// d 後に別の goroutine で f を呼ぶ
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	// 発火前に止められたら true を返す
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+var (
+	Real Clock = realClock{}
+)
@@ -0,0 +1,115 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake は Advance を呼んだときだけ進む Clock
+//
+// Advance は期限の来たタイマーを期限の順に呼び出し側の goroutine で発火させる
+// 一度に一つずつ発火させ、関数が戻ってから次の期限を探すので、
+// 関数の中 (または関数が戻るまで待つ別の goroutine) で設定したタイマーも同じ Advance で発火する
+// 発火した関数が待たない非同期の処理は待たない
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *Fake
+	deadline time.Time
+	f        func()
+	done     bool
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{
+		clock:    c,
+		deadline: c.now.Add(d),
+		f:        f,
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// 時刻を d 進めて、その間に期限が来たタイマーを発火させる
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		next := c.nextTimerLocked(target)
+		if next == nil {
+			c.now = target
+			c.mu.Unlock()
+			return
+		}
+		next.done = true
+		if next.deadline.After(c.now) {
+			c.now = next.deadline
+		}
+		c.mu.Unlock()
+
+		// f の中から AfterFunc が呼ばれてもいいように lock を外して呼ぶ
+		next.f()
+	}
+}
+
+// target までに期限が来る一番早いタイマーを返し、終わったタイマーを取り除く
+func (c *Fake) nextTimerLocked(target time.Time) *fakeTimer {
+	var next *fakeTimer
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.done {
+			continue
+		}
+		pending = append(pending, t)
+		if t.deadline.After(target) {
+			continue
+		}
+		if next == nil || t.deadline.Before(next.deadline) {
+			next = t
+		}
+	}
+	c.timers = pending
+	return next
+}
+
+// 発火を待っているタイマーの数
+func (c *Fake) PendingTimers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if !t.done {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if t.done {
+		return false
+	}
+	t.done = true
+	return true
+}
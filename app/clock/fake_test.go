@@ -0,0 +1,112 @@
+package clock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeAdvanceFiresTimersInOrder(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	fired := []string{}
+	firedAt := []time.Duration{}
+	record := func(name string) func() {
+		return func() {
+			fired = append(fired, name)
+			firedAt = append(firedAt, c.Now().Sub(start))
+		}
+	}
+	c.AfterFunc(3*time.Minute, record("c"))
+	c.AfterFunc(1*time.Minute, record("a"))
+	c.AfterFunc(2*time.Minute, record("b"))
+
+	c.Advance(90 * time.Second)
+	if want := []string{"a"}; !reflect.DeepEqual(fired, want) {
+		t.Fatalf("fired = %v, want %v", fired, want)
+	}
+
+	c.Advance(10 * time.Minute)
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(fired, want) {
+		t.Fatalf("fired = %v, want %v", fired, want)
+	}
+	if want := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}; !reflect.DeepEqual(firedAt, want) {
+		t.Errorf("fired at %v, want %v", firedAt, want)
+	}
+	if got, want := c.Now().Sub(start), 11*time.Minute+30*time.Second; got != want {
+		t.Errorf("now = %v, want %v", got, want)
+	}
+}
+
+func TestFakeStop(t *testing.T) {
+	c := NewFake(time.Time{})
+
+	fired := false
+	timer := c.AfterFunc(time.Minute, func() { fired = true })
+	if !timer.Stop() {
+		t.Error("Stop() = false for a pending timer")
+	}
+	if timer.Stop() {
+		t.Error("Stop() = true for a stopped timer")
+	}
+
+	c.Advance(time.Hour)
+	if fired {
+		t.Error("stopped timer fired")
+	}
+	if n := c.PendingTimers(); n != 0 {
+		t.Errorf("PendingTimers() = %d, want 0", n)
+	}
+}
+
+func TestFakeTimerArmedInsideCallback(t *testing.T) {
+	c := NewFake(time.Time{})
+
+	count := 0
+	var tick func()
+	tick = func() {
+		count++
+		c.AfterFunc(time.Minute, tick)
+	}
+	c.AfterFunc(time.Minute, tick)
+
+	c.Advance(5 * time.Minute)
+	if count != 5 {
+		t.Errorf("count = %d, want 5", count)
+	}
+}
+
+// 別の goroutine が次のタイマーを設定し終えるまで関数が戻らなければ、
+// 一度の Advance でいくつもの期限をまたいで進む
+func TestFakeTimerArmedByConsumer(t *testing.T) {
+	c := NewFake(time.Time{})
+
+	events := make(chan chan struct{})
+	count := 0
+	var arm func()
+	arm = func() {
+		c.AfterFunc(time.Minute, func() {
+			handled := make(chan struct{})
+			events <- handled
+			<-handled
+		})
+	}
+	go func() {
+		for handled := range events {
+			count++
+			arm()
+			close(handled)
+		}
+	}()
+	arm()
+
+	c.Advance(3*time.Minute + 30*time.Second)
+	close(events)
+	if count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+	if got := c.PendingTimers(); got != 1 {
+		t.Errorf("pending timers = %d, want 1", got)
+	}
+}
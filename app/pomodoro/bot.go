@@ -0,0 +1,240 @@
+package pomodoro
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+// GuildSettings は bot を動かすギルドごとの設定
+type GuildSettings struct {
+	GuildInfo
+	// ポモドーロの初期設定 (nil ならデフォルト)
+	Config *GuildConfig
+}
+
+type BotConfig struct {
+	Token  string
+	Guilds []GuildSettings
+	// 設定やセッションを保存するディレクトリ
+	// 空の場合は保存せず、終了すると消える
+	DataDir string
+}
+
+func (c BotConfig) Validate() error {
+	if c.Token == "" {
+		return fmt.Errorf("no discord token exists")
+	}
+	if len(c.Guilds) == 0 {
+		return fmt.Errorf("no guild is configured")
+	}
+	for _, g := range c.Guilds {
+		if err := g.Validate(); err != nil {
+			return err
+		}
+		if g.Config != nil {
+			if err := g.Config.Validate(); err != nil {
+				return fmt.Errorf("guild %s: %w", g.GuildID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c BotConfig) guildInfos() []GuildInfo {
+	infos := []GuildInfo{}
+	for _, g := range c.Guilds {
+		infos = append(infos, g.GuildInfo)
+	}
+	return infos
+}
+
+// Bot は Discord への接続とコマンドの登録を管理する
+// NewBot ではまだ接続せず、Open で接続して Close で後片付けをする
+type Bot struct {
+	config  BotConfig
+	session *discordgo.Session
+	// 設定やセッションの保存先
+	store  storage.Store
+	bundle *i18n.Bundle
+
+	guildInfoMapLock sync.Mutex
+	guildInfoMap     map[GuildID]GuildInfo
+
+	guildConfigMapLock sync.Mutex
+	// 保存先から読み込んだ設定のキャッシュ
+	guildConfigMap map[GuildID]GuildConfig
+	// 設定ファイルで指定されたギルドごとの初期設定
+	guildDefaultConfigMap map[GuildID]GuildConfig
+
+	// VC ごとに Pomodoro を持つ
+	// VC の ID は Discord 全体で一意なのでギルドをまたいでも衝突しない
+	pomodoroMapLock sync.Mutex
+	pomodoroMap     map[ChannelID]*PomodoroWithLock
+	// 終了処理を始めたら新しい Pomodoro は作らない
+	shuttingDown bool
+
+	// ギルドごとに登録したコマンド
+	registeredCommands map[GuildID][]*discordgo.ApplicationCommand
+	// 連続記録のリマインダーを止める
+	stopReminders context.CancelFunc
+	remindersDone chan struct{}
+}
+
+func NewBot(config BotConfig) (*Bot, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	session, err := discordgo.New("Bot " + config.Token)
+	if err != nil {
+		return nil, fmt.Errorf("error in create session: %w", err)
+	}
+
+	var store storage.Store = storage.NewMemory()
+	if config.DataDir != "" {
+		if store, err = storage.OpenFile(config.DataDir); err != nil {
+			return nil, fmt.Errorf("failed to open data dir: %w", err)
+		}
+	}
+	b := newBot(store)
+	b.config = config
+	b.session = session
+
+	if err := b.SetGuildInfos(config.guildInfos()); err != nil {
+		store.Close()
+		return nil, err
+	}
+	for _, g := range config.Guilds {
+		if g.Config == nil {
+			continue
+		}
+		if err := b.setGuildDefaultConfig(g.GuildID, *g.Config); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("guild %s: %w", g.GuildID, err)
+		}
+	}
+
+	session.AddHandler(pingPongMessageHandler)
+	session.AddHandler(b.onVoiceStateUpdate)
+	session.AddHandler(b.onInteractionCreate)
+	session.AddHandler(b.onGuildCreate)
+
+	return b, nil
+}
+
+// Discord に接続せずに使える Bot を作る
+// ギルドは SetGuildInfos で設定する
+func newBot(store storage.Store) *Bot {
+	return &Bot{
+		store:                 store,
+		bundle:                newI18nBundle(),
+		guildInfoMap:          make(map[GuildID]GuildInfo),
+		guildConfigMap:        make(map[GuildID]GuildConfig),
+		guildDefaultConfigMap: make(map[GuildID]GuildConfig),
+		pomodoroMap:           make(map[ChannelID]*PomodoroWithLock),
+		registeredCommands:    make(map[GuildID][]*discordgo.ApplicationCommand),
+	}
+}
+
+func (b *Bot) onInteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
+			h(b, s, i)
+		}
+	case discordgo.InteractionMessageComponent:
+		// CustomID の ":" 以降はハンドラに渡す引数
+		name, _, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+		if h, ok := componentHandlers[name]; ok {
+			h(b, s, i)
+		}
+	}
+}
+
+// Open は Discord に接続して、設定されたすべてのギルドにコマンドを登録する
+func (b *Bot) Open(ctx context.Context) error {
+	if err := b.session.Open(); err != nil {
+		return fmt.Errorf("error in open session: %w", err)
+	}
+
+	for _, g := range b.config.Guilds {
+		for _, v := range commands {
+			if err := ctx.Err(); err != nil {
+				b.Close()
+				return err
+			}
+			cmd, err := b.session.ApplicationCommandCreate(b.session.State.User.ID, g.GuildID, v)
+			if err != nil {
+				b.Close()
+				return fmt.Errorf("cannot create '%v' command in guild %s: %w", v.Name, g.GuildID, err)
+			}
+			b.registeredCommands[g.GuildID] = append(b.registeredCommands[g.GuildID], cmd)
+		}
+	}
+
+	reminderCtx, stop := context.WithCancel(context.Background())
+	b.stopReminders = stop
+	b.remindersDone = make(chan struct{})
+	go func() {
+		defer close(b.remindersDone)
+		b.runStreakReminders(reminderCtx, NewDiscord(b.session))
+	}()
+
+	log.Print("bot is running...")
+	return nil
+}
+
+// Shutdown は動いているポモドーロをすべて止めてメンバーを解放してから Close する
+// ctx の期限を過ぎたら、止まっていないポモドーロを待たずに Close する
+// その後にポモドーロが書き込もうとしても、閉じた保存先は何もせずにエラーを返す
+func (b *Bot) Shutdown(ctx context.Context) error {
+	log.Println("Stopping pomodoros...")
+	err := b.shutdownPomodoros(ctx)
+	if err != nil {
+		log.Print(err)
+	}
+	if cerr := b.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close は登録したコマンドを削除して接続を閉じる
+// 1 つのコマンドの削除に失敗しても残りの後片付けは続ける
+func (b *Bot) Close() error {
+	var firstErr error
+
+	if b.stopReminders != nil {
+		b.stopReminders()
+		<-b.remindersDone
+		b.stopReminders = nil
+	}
+
+	log.Println("Removing commands...")
+	for guildID, cmds := range b.registeredCommands {
+		for _, v := range cmds {
+			if err := b.session.ApplicationCommandDelete(b.session.State.User.ID, guildID, v.ID); err != nil {
+				log.Printf("Cannot delete '%v' command in guild %s: %v", v.Name, guildID, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	b.registeredCommands = make(map[GuildID][]*discordgo.ApplicationCommand)
+
+	if err := b.session.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if err := b.store.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
@@ -0,0 +1,200 @@
+package pomodoro
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+// Discord に接続しない Bot (testGuildInfo だけが設定されている)
+func newTestBot(t *testing.T) *Bot {
+	t.Helper()
+	b := newBot(storage.NewMemory())
+	if err := b.SetGuildInfos([]GuildInfo{testGuildInfo()}); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestNewBot(t *testing.T) {
+	config := BotConfig{
+		Token: "test",
+		Guilds: []GuildSettings{
+			{
+				GuildInfo: GuildInfo{
+					GuildID:                  "guild1",
+					ChannelIDForNotification: "text1",
+					Rooms:                    []RoomInfo{{VoiceChannelID: "vc1"}},
+				},
+			},
+			{
+				GuildInfo: GuildInfo{
+					GuildID:                  "guild2",
+					ChannelIDForNotification: "text2",
+					Rooms: []RoomInfo{
+						{VoiceChannelID: "vc2"},
+						{VoiceChannelID: "vc3", ChannelIDForNotification: "text3", Schedule: "50w 10b"},
+					},
+					Locale: "en",
+				},
+			},
+		},
+	}
+
+	// 接続は Open まで行わない
+	bot, err := NewBot(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bot.session == nil {
+		t.Error("session is not created")
+	}
+	for _, g := range config.Guilds {
+		if got, ok := bot.LookupGuildInfo(g.GuildID); !ok || !reflect.DeepEqual(got, g.GuildInfo) {
+			t.Errorf("LookupGuildInfo(%s) = %+v, %v", g.GuildID, got, ok)
+		}
+	}
+	if _, ok := bot.LookupGuildInfo("other"); ok {
+		t.Error("unconfigured guild was found")
+	}
+
+	invalid := config
+	invalid.Token = ""
+	if _, err := NewBot(invalid); err == nil {
+		t.Error("NewBot() without a token did not fail")
+	}
+}
+
+func TestLoadGuildSettings(t *testing.T) {
+	settings, err := LoadGuildSettings(strings.NewReader(`{
+		"guilds": [
+			{"guild_id": "guild1", "notification_channel_id": "text1", "pomodoro_vc_id": "vc1"},
+			{"guild_id": "guild2", "notification_channel_id": "text2", "rooms": [{"vc_id": "vc2"}, {"vc_id": "vc3", "notification_channel_id": "text3", "enforcement": "mute"}], "locale": "en", "time_zone": "UTC", "task": "50m", "break": "10m", "enforcement": "none", "streak_goal": 2}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settings) != 2 {
+		t.Fatalf("got %d guilds, want 2", len(settings))
+	}
+	if settings[0].Config != nil {
+		t.Errorf("guild1 config = %+v, want nil", settings[0].Config)
+	}
+	c := settings[1].Config
+	if c == nil || c.TaskDuration != 50*time.Minute || c.BreakDuration != 10*time.Minute || c.Enforcement != EnforcementNone || c.StreakGoal != 2 {
+		t.Errorf("guild2 config = %+v", c)
+	}
+	if room, ok := settings[1].Room("vc2"); !ok || room.ChannelIDForNotification != "text2" {
+		t.Errorf("guild2 room vc2 = %+v, %v", room, ok)
+	}
+	if room, ok := settings[1].Room("vc3"); !ok || room.ChannelIDForNotification != "text3" || room.Enforcement != EnforcementMute {
+		t.Errorf("guild2 room vc3 = %+v, %v", room, ok)
+	}
+	if settings[1].Locale != "en" {
+		t.Errorf("guild2 locale = %q, want en", settings[1].Locale)
+	}
+	if settings[1].location() != time.UTC {
+		t.Errorf("guild2 location = %s, want UTC", settings[1].location())
+	}
+
+	for _, invalid := range []string{
+		`{"guilds": [{"guild_id": "guild1"}]}`,
+		`{"guilds": [{"guild_id": "guild1", "notification_channel_id": "text1", "rooms": [{"vc_id": "vc1"}, {"vc_id": "vc1"}]}]}`,
+		`{"guilds": [{"guild_id": "guild1", "rooms": [{"vc_id": "vc1"}]}]}`,
+		`{"guilds": [{"guild_id": "guild1", "notification_channel_id": "text1", "pomodoro_vc_id": "vc1", "task": "-1m"}]}`,
+		`{"guilds": [{"guild_id": "guild1", "notification_channel_id": "text1", "pomodoro_vc_id": "vc1", "unknown": 1}]}`,
+		`{"guilds": [{"guild_id": "guild1", "notification_channel_id": "text1", "rooms": [{"vc_id": "vc1", "enforcement": "kick"}]}]}`,
+		`{"guilds": [{"guild_id": "guild1", "notification_channel_id": "text1", "pomodoro_vc_id": "vc1", "enforcement": "default"}]}`,
+		`{"guilds": [{"guild_id": "guild1", "notification_channel_id": "text1", "pomodoro_vc_id": "vc1", "time_zone": "Mars/Olympus"}]}`,
+	} {
+		if _, err := LoadGuildSettings(strings.NewReader(invalid)); err == nil {
+			t.Errorf("LoadGuildSettings(%s) did not fail", invalid)
+		}
+	}
+}
+
+func TestGuildConfigIsPersisted(t *testing.T) {
+	s := storage.NewMemory()
+	b := newBot(s)
+
+	initial, err := DefaultGuildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial.TaskDuration = 50 * time.Minute
+	if err := b.setGuildDefaultConfig("guild", initial); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := b.GetGuildConfig("guild"); err != nil || c.TaskDuration != 50*time.Minute {
+		t.Errorf("GetGuildConfig() before saving = %+v, %v", c, err)
+	}
+
+	updated := initial
+	updated.TaskDuration = 40 * time.Minute
+	updated.Reminders = Reminders{PhaseKindTask: {5 * time.Minute}}
+	updated.Mode = PomodoroModeFlowtime
+	if err := b.SetGuildConfig("guild", updated); err != nil {
+		t.Fatal(err)
+	}
+
+	// キャッシュを持たない Bot でも保存先から読める
+	c, err := newBot(s).GetGuildConfig("guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TaskDuration != 40*time.Minute || c.Mode != PomodoroModeFlowtime || !reflect.DeepEqual(c.Reminders[PhaseKindTask], []time.Duration{5 * time.Minute}) {
+		t.Errorf("GetGuildConfig() after reload = %+v", c)
+	}
+}
+
+// 同じギルドを設定した Bot でも、ポモドーロや設定は共有しない
+func TestBotsAreIndependent(t *testing.T) {
+	b1 := newTestBot(t)
+	b2 := newTestBot(t)
+	discord := newFakeDiscord()
+
+	config, err := DefaultGuildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.TaskDuration = 50 * time.Minute
+	if err := b1.SetGuildConfig(testGuildID, config); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := b2.GetGuildConfig(testGuildID); err != nil || c.TaskDuration == 50*time.Minute {
+		t.Errorf("config of the other bot = %+v, %v", c, err)
+	}
+
+	b1.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", testVoiceChannelID))
+	t.Cleanup(func() {
+		b1.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", testVoiceChannelID, ""))
+	})
+	if _, ok := b1.findRoomWithMember(testGuildInfo(), "alice"); !ok {
+		t.Fatal("alice did not join")
+	}
+	if pp := b2.lookupPomodoroWithLock(testVoiceChannelID); pp != nil {
+		t.Error("pomodoro was created in the other bot")
+	}
+
+	// 片方を終了しても、もう片方は新しく始められる
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b2.shutdownPomodoros(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b1.findRoomWithMember(testGuildInfo(), "alice"); !ok {
+		t.Error("alice was removed by the other bot")
+	}
+	b1.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", "", "vc2"))
+	t.Cleanup(func() {
+		b1.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", "vc2", ""))
+	})
+	if _, ok := b1.findRoomWithMember(testGuildInfo(), "bob"); !ok {
+		t.Error("bob could not join after the other bot shut down")
+	}
+}
@@ -3,11 +3,25 @@ package pomodoro
 import (
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
 )
 
 var (
+	longBreakIntervalMinValue float64 = 1
+	extendMinutesMinValue     float64 = 1
+	cyclesMinValue            float64 = 0
+	streakGoalMinValue        float64 = 1
+	heatmapWeeksMinValue      float64 = 1
+
+	modeChoices = []*discordgo.ApplicationCommandOptionChoice{
+		{Name: "pomodoro", Value: PomodoroModePomodoro.String()},
+		{Name: "flowtime", Value: PomodoroModeFlowtime.String()},
+	}
+
 	commands = []*discordgo.ApplicationCommand{
 		{
 			Name:        "pomodoro",
@@ -22,21 +36,245 @@ var (
 					Name:        "start",
 					Description: "start pomodoro",
 					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "schedule",
+							Description: "schedule of this session (e.g. \"50w 10b\", \"25w 5b 25w 5b 25w 15b stop\")",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "cycles",
+							Description: "stop automatically after N breaks (0 for no limit)",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							MinValue:    &cyclesMinValue,
+						},
+						{
+							Name:        "mode",
+							Description: "pomodoro (fixed schedule) or flowtime (take a break whenever you like)",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices:     modeChoices,
+						},
+					},
 				},
 				{
 					Name:        "stop",
 					Description: "stop pomodoro",
 					Type:        discordgo.ApplicationCommandOptionSubCommand,
 				},
+				{
+					Name:        "pause",
+					Description: "pause pomodoro",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "resume",
+					Description: "resume paused pomodoro",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "break",
+					Description: "take a break (flowtime mode)",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "skip",
+					Description: "skip the current phase",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+				},
+				{
+					Name:        "extend",
+					Description: "extend the current phase",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "minutes",
+							Description: "minutes to extend",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Required:    true,
+							MinValue:    &extendMinutesMinValue,
+						},
+					},
+				},
+				{
+					Name:        "config",
+					Description: "show or update pomodoro durations of this server",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "task",
+							Description: "task duration (e.g. 25m)",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "break",
+							Description: "break duration (e.g. 5m)",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "task_reminders",
+							Description: "remind before the end of a task (e.g. \"5m,1m\", or \"none\")",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "break_reminders",
+							Description: "remind before the end of a break (e.g. \"1m,10s\", or \"none\")",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "long_break_reminders",
+							Description: "remind before the end of a long break (e.g. \"1m,10s\", or \"none\")",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "long_break",
+							Description: "long break duration (e.g. 15m)",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "long_break_interval",
+							Description: "take a long break after every N tasks",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							MinValue:    &longBreakIntervalMinValue,
+						},
+						{
+							Name:        "schedule",
+							Description: "default schedule (e.g. \"50w 10b\"), or \"default\" to build it from the durations",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "cycles",
+							Description: "stop automatically after N breaks by default (0 for no limit)",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							MinValue:    &cyclesMinValue,
+						},
+						{
+							Name:        "mode",
+							Description: "default mode",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices:     modeChoices,
+						},
+						{
+							Name:        "flowtime_ratio",
+							Description: "break length relative to focus time in flowtime mode (e.g. 1/5)",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "enforcement",
+							Description: "what to do to members during a task (rooms may override it)",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices:     enforcementChoices(false),
+						},
+						{
+							Name:        "streak_goal",
+							Description: "pomodoros a day to keep a streak",
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							MinValue:    &streakGoalMinValue,
+						},
+					},
+				},
+				{
+					Name:        "prefs",
+					Description: "show or update your own preferences in this server",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "enforcement",
+							Description: "what to do to you during a task (default to follow the room)",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Choices:     enforcementChoices(true),
+						},
+						{
+							Name:        "time_zone",
+							Description: "time zone for your streak (e.g. Asia/Tokyo), or \"default\" to follow the server",
+							Type:        discordgo.ApplicationCommandOptionString,
+						},
+						{
+							Name:        "streak_reminder",
+							Description: "get a DM in the evening when your streak is about to end",
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+						},
+					},
+				},
+				{
+					Name:        "stats",
+					Description: "show completed pomodoros and focus time",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "user",
+							Description: "whose stats to show (default to you)",
+							Type:        discordgo.ApplicationCommandOptionUser,
+						},
+					},
+				},
+				{
+					Name:        "leaderboard",
+					Description: "rank members by completed pomodoros and focus time",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "period",
+							Description: "period to rank",
+							Type:        discordgo.ApplicationCommandOptionString,
+							Required:    true,
+							Choices:     leaderboardPeriodChoices,
+						},
+					},
+				},
+				{
+					Name:        "heatmap",
+					Description: "show a heatmap of completed pomodoros a day",
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "user",
+							Description: "whose heatmap to show (default to you)",
+							Type:        discordgo.ApplicationCommandOptionUser,
+						},
+						{
+							Name:        "weeks",
+							Description: fmt.Sprintf("number of weeks to show (default %d)", HeatmapDefaultWeeks),
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							MinValue:    &heatmapWeeksMinValue,
+							MaxValue:    HeatmapMaxWeeks,
+						},
+					},
+				},
+				{
+					Name:        "mydata",
+					Description: "export or delete what the bot stores about you",
+					Type:        discordgo.ApplicationCommandOptionSubCommandGroup,
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Name:        "export",
+							Description: "get your history and preferences by direct message",
+							Type:        discordgo.ApplicationCommandOptionSubCommand,
+						},
+						{
+							Name:        "delete",
+							Description: "delete your history and preferences in this server",
+							Type:        discordgo.ApplicationCommandOptionSubCommand,
+						},
+					},
+				},
 			},
 		},
 	}
 
-	commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"pomodoro": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	commandHandlers = map[string]func(b *Bot, s *discordgo.Session, i *discordgo.InteractionCreate){
+		"pomodoro": func(b *Bot, s *discordgo.Session, i *discordgo.InteractionCreate) {
 			log.Printf("pomodoro command: %+v", i.ApplicationCommandData())
+			guild, ok := b.LookupGuildInfo(i.GuildID)
+			if !ok {
+				log.Printf("Guild (%s) is not configured", i.GuildID)
+				return
+			}
 			options := i.ApplicationCommandData().Options
 			content := ""
+			var flags uint64
+			var embeds []*discordgo.MessageEmbed
+			var components []discordgo.MessageComponent
+			var files []*discordgo.File
 
 			switch options[0].Name {
 			case "ping":
@@ -44,67 +282,561 @@ var (
 
 			case "start":
 				user := i.Member.User
-				content = fmt.Sprintf("Hi %s! See <#%s>!", user.Username, Info.GetChannelIDForNotification())
+
+				var schedule *Schedule
+				if opt := findOption(options[0].Options, "schedule"); opt != nil {
+					parsed, err := ParseSchedule(opt.StringValue())
+					if err != nil {
+						content = fmt.Sprintf("Invalid schedule: %v", err)
+						break
+					}
+					schedule = &parsed
+				}
+				cycles := -1
+				if opt := findOption(options[0].Options, "cycles"); opt != nil {
+					cycles = int(opt.IntValue())
+				}
+				var mode *PomodoroMode
+				if opt := findOption(options[0].Options, "mode"); opt != nil {
+					parsed, err := ParsePomodoroMode(opt.StringValue())
+					if err != nil {
+						content = fmt.Sprintf("Invalid mode: %v", err)
+						break
+					}
+					mode = &parsed
+				}
 
 				// user が VC にいるかどうかを確認する
 				var voiceState *discordgo.VoiceState
 				var err error
-				if voiceState, err = s.State.VoiceState(Info.GetGuildID(), user.ID); err != nil {
+				if voiceState, err = s.State.VoiceState(guild.GuildID, user.ID); err != nil {
 					log.Printf("Failed to get %s's voice state: %v", user.Username, err)
 				}
 				if voiceState == nil {
 					log.Printf("User is not in voice channel")
-					content += "\n"
-					content += "You are not in voice channel."
+					content = "You are not in voice channel."
 					break
 				}
 
 				log.Printf("User is in voice channel: %v", voiceState.ChannelID)
 
+				// ポモドーロ用の VC でなければ開始しない
+				room, ok := guild.Room(voiceState.ChannelID)
+				if !ok {
+					content = fmt.Sprintf("<#%s> is not a pomodoro voice channel.", voiceState.ChannelID)
+					content += "\n"
+					content += "Join one of " + roomMentions(guild) + "."
+					break
+				}
+				content = fmt.Sprintf("Hi %s! See <#%s>!", user.Username, room.ChannelIDForNotification)
+
 				// VC にいる場合は pomodoro を開始する
-				if pomodoro, err := getPomodoroWithLock(s, i.GuildID, Info.GetChannelIDForNotification()); err != nil {
+				if pomodoro, err := b.getPomodoroWithLock(NewDiscord(s), guild, room); err != nil {
 					log.Println(err)
 					return
 				} else {
-					defer unlockPomodoro(Info.GetGuildID())
-					pomodoro.AddUser(*user)
+					defer b.unlockPomodoro(room.VoiceChannelID)
+					if schedule != nil {
+						if err := pomodoro.SetSchedule(*schedule); err != nil {
+							content += "\n"
+							content += "A pomodoro is already running, so the schedule was not changed."
+						}
+					}
+					if cycles >= 0 {
+						if err := pomodoro.SetCycles(cycles); err != nil {
+							content += "\n"
+							content += "A pomodoro is already running, so the cycles were not changed."
+						}
+					}
+					if mode != nil {
+						if err := pomodoro.SetMode(*mode); err != nil {
+							content += "\n"
+							content += "A pomodoro is already running, so the mode was not changed."
+						}
+					}
+					pomodoro.AddUser(*user, voiceFlagsOf(voiceState))
 				}
 			case "stop":
 				user := i.Member.User
-				content = fmt.Sprintf("Bye %s! See <#%s>!", user.Username, Info.GetChannelIDForNotification())
 
 				// user が VC にいるかどうかを確認する
 				var voiceState *discordgo.VoiceState
 				var err error
-				if voiceState, err = s.State.VoiceState(Info.GetGuildID(), user.ID); err != nil {
+				if voiceState, err = s.State.VoiceState(guild.GuildID, user.ID); err != nil {
 					log.Printf("Failed to get %s's voice state: %v", user.Username, err)
 				}
+
+				room, ok := b.findUserRoom(s, guild, user.ID)
+				if !ok {
+					content = "You are not in any pomodoro."
+					break
+				}
+				content = fmt.Sprintf("Bye %s! See <#%s>!", user.Username, room.ChannelIDForNotification)
+
 				if voiceState == nil {
 					log.Printf("User is not in voice channel")
 					// いなければ忠告
 					content += "\n"
-					content += fmt.Sprintf("<@%s>! You should run `/pomodoro stop` command in some voice channel.", user.ID)
+					content += fmt.Sprintf("<@%s>! You are not in a voice channel now.", user.ID)
 					content += "\n"
-					content += "Otherwise, your server mute and deaf status may not be released."
+					content += "Your server mute and deaf status will be released the next time you join a voice channel."
 				}
 
 				// pomodoro を停止
-				if pomodoro, err := getPomodoroWithLock(s, i.GuildID, Info.GetChannelIDForNotification()); err != nil {
+				if pomodoro, err := b.getPomodoroWithLock(NewDiscord(s), guild, room); err != nil {
 					log.Println(err)
 					return
 				} else {
-					defer releaseOrUnlockPomodoro(pomodoro, i.GuildID)
+					defer b.releaseOrUnlockPomodoro(pomodoro, room.VoiceChannelID)
 					pomodoro.RemoveMember(user.ID)
 				}
+			case "pause", "resume":
+				content = b.pauseOrResumeCommand(s, guild, i.Member.User.ID, options[0].Name == "pause")
+			case "break":
+				content = b.takeBreakCommand(s, guild, i.Member.User.ID)
+			case "skip":
+				content = b.skipCommand(s, guild, i.Member.User.ID)
+			case "extend":
+				content = b.extendCommand(s, guild, i.Member.User.ID, time.Duration(options[0].Options[0].IntValue())*time.Minute)
+			case "config":
+				content = b.configCommand(i.GuildID, options[0].Options)
+			case "prefs":
+				content = b.prefsCommand(s, guild, i.Member.User.ID, options[0].Options)
+				// 本人にだけ見せる
+				flags = uint64(discordgo.MessageFlagsEphemeral)
+			case "stats":
+				user := i.Member.User
+				if len(options[0].Options) > 0 {
+					user = options[0].Options[0].UserValue(s)
+				}
+				var embed *discordgo.MessageEmbed
+				if embed, content = b.statsCommand(guild, user); embed != nil {
+					embeds = append(embeds, embed)
+				}
+			case "leaderboard":
+				period, err := ParseLeaderboardPeriod(options[0].Options[0].StringValue())
+				if err != nil {
+					content = fmt.Sprintf("Invalid period: %v", err)
+					break
+				}
+				var embed *discordgo.MessageEmbed
+				if embed, components, content = b.leaderboardCommand(guild, period, 0); embed != nil {
+					embeds = append(embeds, embed)
+				}
+			case "heatmap":
+				user := i.Member.User
+				if opt := findOption(options[0].Options, "user"); opt != nil {
+					user = opt.UserValue(s)
+				}
+				weeks := HeatmapDefaultWeeks
+				if opt := findOption(options[0].Options, "weeks"); opt != nil {
+					weeks = int(opt.IntValue())
+				}
+				var file *discordgo.File
+				if file, content = b.heatmapCommand(guild, user, weeks); file != nil {
+					files = append(files, file)
+				}
+			case "mydata":
+				content, components = b.mydataCommand(s, guild, i.Member.User.ID, options[0].Options)
+				// 本人にだけ見せる
+				flags = uint64(discordgo.MessageFlagsEphemeral)
 			default:
 			}
 
+			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Content:    content,
+					Flags:      flags,
+					Embeds:     embeds,
+					Components: components,
+					Files:      files,
+				},
+			})
+		},
+	}
+)
+
+func (b *Bot) pauseOrResumeCommand(s *discordgo.Session, guild GuildInfo, userID UserID, pause bool) string {
+	room, ok := b.findUserRoom(s, guild, userID)
+	if !ok {
+		return "You are not in any pomodoro."
+	}
+	pomodoro, err := b.getPomodoroWithLock(NewDiscord(s), guild, room)
+	if err != nil {
+		log.Println(err)
+		return "Failed to get pomodoro."
+	}
+	// 動いていなければ生成したばかりの Pomodoro を破棄する
+	defer b.releaseOrUnlockPomodoro(pomodoro, room.VoiceChannelID)
+
+	if pause {
+		err = pomodoro.Pause()
+	} else {
+		err = pomodoro.Resume()
+	}
+	if err != nil {
+		log.Printf("Failed to pause/resume pomodoro: %v", err)
+		return fmt.Sprintf("Cannot do that: %v", err)
+	}
+
+	if pause {
+		return fmt.Sprintf("Paused! See <#%s>!", room.ChannelIDForNotification)
+	}
+	return fmt.Sprintf("Resumed! See <#%s>!", room.ChannelIDForNotification)
+}
+
+func (b *Bot) takeBreakCommand(s *discordgo.Session, guild GuildInfo, userID UserID) string {
+	room, ok := b.findUserRoom(s, guild, userID)
+	if !ok {
+		return "You are not in any pomodoro."
+	}
+	pomodoro, err := b.getPomodoroWithLock(NewDiscord(s), guild, room)
+	if err != nil {
+		log.Println(err)
+		return "Failed to get pomodoro."
+	}
+	defer b.releaseOrUnlockPomodoro(pomodoro, room.VoiceChannelID)
+
+	if !pomodoro.HasMember(userID) {
+		return "Only members of the pomodoro can take a break."
+	}
+	if err := pomodoro.TakeBreak(); err != nil {
+		log.Printf("Failed to take a break: %v", err)
+		return fmt.Sprintf("Cannot do that: %v", err)
+	}
+	return fmt.Sprintf("Break time! See <#%s>!", room.ChannelIDForNotification)
+}
+
+func (b *Bot) skipCommand(s *discordgo.Session, guild GuildInfo, userID UserID) string {
+	room, ok := b.findUserRoom(s, guild, userID)
+	if !ok {
+		return "You are not in any pomodoro."
+	}
+	pomodoro, err := b.getPomodoroWithLock(NewDiscord(s), guild, room)
+	if err != nil {
+		log.Println(err)
+		return "Failed to get pomodoro."
+	}
+	defer b.releaseOrUnlockPomodoro(pomodoro, room.VoiceChannelID)
+
+	if err := pomodoro.Skip(); err != nil {
+		log.Printf("Failed to skip pomodoro: %v", err)
+		return fmt.Sprintf("Cannot do that: %v", err)
+	}
+	return fmt.Sprintf("Skipped! See <#%s>!", room.ChannelIDForNotification)
+}
+
+func (b *Bot) extendCommand(s *discordgo.Session, guild GuildInfo, userID UserID, d time.Duration) string {
+	room, ok := b.findUserRoom(s, guild, userID)
+	if !ok {
+		return "You are not in any pomodoro."
+	}
+	pomodoro, err := b.getPomodoroWithLock(NewDiscord(s), guild, room)
+	if err != nil {
+		log.Println(err)
+		return "Failed to get pomodoro."
+	}
+	defer b.releaseOrUnlockPomodoro(pomodoro, room.VoiceChannelID)
+
+	if err := pomodoro.Extend(d); err != nil {
+		log.Printf("Failed to extend pomodoro: %v", err)
+		return fmt.Sprintf("Cannot do that: %v", err)
+	}
+	return fmt.Sprintf("Extended! See <#%s>!", room.ChannelIDForNotification)
+}
+
+var (
+	componentHandlers = map[string]func(b *Bot, s *discordgo.Session, i *discordgo.InteractionCreate){
+		takeBreakButtonCustomID: func(b *Bot, s *discordgo.Session, i *discordgo.InteractionCreate) {
+			guild, ok := b.LookupGuildInfo(i.GuildID)
+			if !ok {
+				log.Printf("Guild (%s) is not configured", i.GuildID)
+				return
+			}
+			content := b.takeBreakCommand(s, guild, i.Member.User.ID)
 			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 				Type: discordgo.InteractionResponseChannelMessageWithSource,
 				Data: &discordgo.InteractionResponseData{
 					Content: content,
+					Flags:   uint64(discordgo.MessageFlagsEphemeral),
+				},
+			})
+		},
+		leaderboardButtonCustomID: func(b *Bot, s *discordgo.Session, i *discordgo.InteractionCreate) {
+			guild, ok := b.LookupGuildInfo(i.GuildID)
+			if !ok {
+				log.Printf("Guild (%s) is not configured", i.GuildID)
+				return
+			}
+			_, args, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+			period, page, err := parseLeaderboardButtonArgs(args)
+			if err != nil {
+				log.Printf("Failed to parse leaderboard button: %v", err)
+				return
+			}
+			embed, components, content := b.leaderboardCommand(guild, period, page)
+			if embed == nil {
+				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+					Type: discordgo.InteractionResponseChannelMessageWithSource,
+					Data: &discordgo.InteractionResponseData{
+						Content: content,
+						Flags:   uint64(discordgo.MessageFlagsEphemeral),
+					},
+				})
+				return
+			}
+			// 押されたメッセージを次のページに書き換える
+			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseUpdateMessage,
+				Data: &discordgo.InteractionResponseData{
+					Embeds:     []*discordgo.MessageEmbed{embed},
+					Components: components,
+				},
+			})
+		},
+		mydataDeleteButtonCustomID: func(b *Bot, s *discordgo.Session, i *discordgo.InteractionCreate) {
+			guild, ok := b.LookupGuildInfo(i.GuildID)
+			if !ok {
+				log.Printf("Guild (%s) is not configured", i.GuildID)
+				return
+			}
+			_, userID, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+			if userID != i.Member.User.ID {
+				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+					Type: discordgo.InteractionResponseChannelMessageWithSource,
+					Data: &discordgo.InteractionResponseData{
+						Content: "You can delete only your own data.",
+						Flags:   uint64(discordgo.MessageFlagsEphemeral),
+					},
+				})
+				return
+			}
+
+			content := "Deleted your pomodoro history and preferences in this server."
+			if err := b.deleteUserData(guild, userID); err != nil {
+				log.Printf("Failed to delete data of %s: %v", userID, err)
+				content = "Failed to delete your data. Please try again later."
+			}
+			// 確認のボタンを消す
+			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseUpdateMessage,
+				Data: &discordgo.InteractionResponseData{
+					Content:    content,
+					Components: []discordgo.MessageComponent{},
 				},
 			})
 		},
 	}
 )
+
+// コマンドを実行したユーザーがいるポモドーロ用 VC のルームを返す
+// VC にいなければ参加しているポモドーロのルームを返す
+func (b *Bot) findUserRoom(s *discordgo.Session, guild GuildInfo, userID UserID) (RoomInfo, bool) {
+	if voiceState, err := s.State.VoiceState(guild.GuildID, userID); err == nil && voiceState != nil {
+		if room, ok := guild.Room(voiceState.ChannelID); ok {
+			return room, true
+		}
+	}
+	return b.findRoomWithMember(guild, userID)
+}
+
+func roomMentions(guild GuildInfo) string {
+	mentions := []string{}
+	for _, room := range guild.Rooms {
+		mentions = append(mentions, "<#"+room.VoiceChannelID+">")
+	}
+	return strings.Join(mentions, ", ")
+}
+
+// オプションがなければ現在の設定を表示し、あれば更新する
+// 更新した設定は次に生成される Pomodoro から反映される
+func (b *Bot) configCommand(guildID GuildID, options []*discordgo.ApplicationCommandInteractionDataOption) string {
+	config, err := b.GetGuildConfig(guildID)
+	if err != nil {
+		log.Printf("Failed to get config of guild (%s): %v", guildID, err)
+		return "Failed to get the current config."
+	}
+
+	if len(options) == 0 {
+		return "Current config:\n" + formatGuildConfig(config)
+	}
+
+	for _, opt := range options {
+		switch opt.Name {
+		case "long_break_interval":
+			config.LongBreakInterval = int(opt.IntValue())
+			continue
+		case "cycles":
+			config.Cycles = int(opt.IntValue())
+			continue
+		case "streak_goal":
+			config.StreakGoal = int(opt.IntValue())
+			continue
+		case "mode":
+			mode, err := ParsePomodoroMode(opt.StringValue())
+			if err != nil {
+				return fmt.Sprintf("Invalid mode: %v", err)
+			}
+			config.Mode = mode
+			continue
+		case "task_reminders", "break_reminders", "long_break_reminders":
+			offsets, err := ParseReminders(opt.StringValue())
+			if err != nil {
+				return fmt.Sprintf("Invalid reminders for `%s`: %v", opt.Name, err)
+			}
+			kind := map[string]PhaseKind{
+				"task_reminders":       PhaseKindTask,
+				"break_reminders":      PhaseKindBreak,
+				"long_break_reminders": PhaseKindLongBreak,
+			}[opt.Name]
+			config.Reminders[kind] = offsets
+			continue
+		case "enforcement":
+			enforcement, err := ParseEnforcement(opt.StringValue())
+			if err != nil || enforcement == EnforcementDefault {
+				return fmt.Sprintf("Invalid enforcement: %s", opt.StringValue())
+			}
+			config.Enforcement = enforcement
+			continue
+		case "flowtime_ratio":
+			ratio, err := ParseRatio(opt.StringValue())
+			if err != nil {
+				return fmt.Sprintf("Invalid ratio: %v", err)
+			}
+			config.FlowtimeBreakRatio = ratio
+			continue
+		case "schedule":
+			if spec := opt.StringValue(); spec == "default" {
+				config.ScheduleSpec = ""
+			} else {
+				config.ScheduleSpec = spec
+			}
+			continue
+		}
+
+		d, err := time.ParseDuration(opt.StringValue())
+		if err != nil {
+			return fmt.Sprintf("Invalid duration for `%s`: %v", opt.Name, err)
+		}
+		switch opt.Name {
+		case "task":
+			config.TaskDuration = d
+		case "break":
+			config.BreakDuration = d
+		case "long_break":
+			config.LongBreakDuration = d
+		}
+	}
+
+	if err := b.SetGuildConfig(guildID, config); err != nil {
+		return fmt.Sprintf("Invalid config: %v", err)
+	}
+
+	return "Updated config (applied from the next pomodoro):\n" + formatGuildConfig(config)
+}
+
+func formatGuildConfig(config GuildConfig) string {
+	content := fmt.Sprintf("- task: `%s`\n", config.TaskDuration)
+	content += fmt.Sprintf("- break: `%s`\n", config.BreakDuration)
+	content += fmt.Sprintf("- long_break: `%s`\n", config.LongBreakDuration)
+	content += fmt.Sprintf("- long_break_interval: `%d`\n", config.LongBreakInterval)
+	content += fmt.Sprintf("- task_reminders: `%s`\n", FormatReminders(config.Reminders[PhaseKindTask]))
+	content += fmt.Sprintf("- break_reminders: `%s`\n", FormatReminders(config.Reminders[PhaseKindBreak]))
+	content += fmt.Sprintf("- long_break_reminders: `%s`\n", FormatReminders(config.Reminders[PhaseKindLongBreak]))
+	content += fmt.Sprintf("- cycles: `%d`\n", config.Cycles)
+	content += fmt.Sprintf("- mode: `%s`\n", config.Mode)
+	content += fmt.Sprintf("- flowtime_ratio: `%g`\n", config.FlowtimeBreakRatio)
+	content += fmt.Sprintf("- enforcement: `%s`\n", config.Enforcement)
+	content += fmt.Sprintf("- streak_goal: `%d`\n", config.StreakGoal)
+	if schedule, err := config.BuildSchedule(); err == nil {
+		if len(config.ScheduleSpec) == 0 {
+			content += fmt.Sprintf("- schedule: `%s` (default)", schedule)
+		} else {
+			content += fmt.Sprintf("- schedule: `%s`", schedule)
+		}
+	}
+	return content
+}
+
+func findOption(options []*discordgo.ApplicationCommandInteractionDataOption, name string) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, opt := range options {
+		if opt.Name == name {
+			return opt
+		}
+	}
+	return nil
+}
+
+// オプションがなければ現在の設定を表示し、あれば更新する
+// 参加中のポモドーロがあればすぐに反映する
+func (b *Bot) prefsCommand(s *discordgo.Session, guild GuildInfo, userID UserID, options []*discordgo.ApplicationCommandInteractionDataOption) string {
+	prefs, _, err := b.store.LoadUserPrefs(guild.GuildID, userID)
+	if err != nil {
+		log.Printf("Failed to load prefs of %s: %v", userID, err)
+		return "Failed to get your preferences."
+	}
+	prefs.GuildID = guild.GuildID
+	prefs.UserID = userID
+
+	if len(options) == 0 {
+		return "Your preferences:\n" + formatUserPrefs(prefs)
+	}
+
+	for _, opt := range options {
+		switch opt.Name {
+		case "enforcement":
+			enforcement, err := ParseEnforcement(opt.StringValue())
+			if err != nil {
+				return fmt.Sprintf("Invalid enforcement: %v", err)
+			}
+			prefs.Enforcement = ""
+			if enforcement != EnforcementDefault {
+				prefs.Enforcement = enforcement.String()
+			}
+		case "time_zone":
+			prefs.TimeZone = ""
+			if tz := opt.StringValue(); tz != "default" {
+				if _, err := time.LoadLocation(tz); err != nil {
+					return fmt.Sprintf("Invalid time zone: %v", err)
+				}
+				prefs.TimeZone = tz
+			}
+		case "streak_reminder":
+			prefs.StreakReminder = opt.BoolValue()
+		}
+	}
+
+	if err := b.store.SaveUserPrefs(prefs); err != nil {
+		log.Printf("Failed to save prefs of %s: %v", userID, err)
+		return "Failed to save your preferences."
+	}
+
+	if room, ok := b.findRoomWithMember(guild, userID); ok {
+		pomodoro, err := b.getPomodoroWithLock(NewDiscord(s), guild, room)
+		if err != nil {
+			log.Println(err)
+		} else {
+			defer b.releaseOrUnlockPomodoro(pomodoro, room.VoiceChannelID)
+			pomodoro.RefreshMember(userID)
+		}
+	}
+
+	return "Updated your preferences:\n" + formatUserPrefs(prefs)
+}
+
+func formatUserPrefs(prefs storage.UserPrefs) string {
+	content := ""
+	if prefs.Enforcement == "" {
+		content += fmt.Sprintf("- enforcement: `%s` (follow the room or server)\n", EnforcementDefault)
+	} else {
+		content += fmt.Sprintf("- enforcement: `%s`\n", prefs.Enforcement)
+	}
+	if prefs.TimeZone == "" {
+		content += "- time_zone: `default` (follow the server)\n"
+	} else {
+		content += fmt.Sprintf("- time_zone: `%s`\n", prefs.TimeZone)
+	}
+	content += fmt.Sprintf("- streak_reminder: `%t`", prefs.StreakReminder)
+	return content
+}
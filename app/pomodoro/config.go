@@ -0,0 +1,166 @@
+package pomodoro
+
+import (
+	"fmt"
+	"time"
+)
+
+// GuildConfig はギルドごとのポモドーロ設定
+// NewPomodoro で Pomodoro を生成するときに参照される
+type GuildConfig struct {
+	TaskDuration      time.Duration
+	BreakDuration     time.Duration
+	Reminders         Reminders
+	LongBreakDuration time.Duration
+	// 何回タスクを終えるごとに長い休憩を取るか
+	LongBreakInterval int
+	// 何回休憩を終えたら自動で停止するか (0 なら停止しない)
+	Cycles int
+	Mode   PomodoroMode
+	// タスク中に何をするか (ルームやユーザーの設定がなければこれに従う)
+	Enforcement Enforcement
+	// flowtime mode で集中した時間に対する休憩時間の割合
+	FlowtimeBreakRatio float64
+	// ParseSchedule で解釈できる文字列
+	// 空の場合は上の設定から DefaultSchedule を作る
+	ScheduleSpec string
+	// 1 日に何回タスクを終えれば連続記録が続くか
+	StreakGoal int
+}
+
+func DefaultGuildConfig() (GuildConfig, error) {
+	taskDuration, err := time.ParseDuration(PomodoroTaskDuration)
+	if err != nil {
+		return GuildConfig{}, err
+	}
+
+	breakDuration, err := time.ParseDuration(PomodoroBreakDuration)
+	if err != nil {
+		return GuildConfig{}, err
+	}
+
+	reminders, err := DefaultReminders()
+	if err != nil {
+		return GuildConfig{}, err
+	}
+
+	longBreakDuration, err := time.ParseDuration(PomodoroLongBreakDuration)
+	if err != nil {
+		return GuildConfig{}, err
+	}
+
+	flowtimeBreakRatio, err := ParseRatio(PomodoroFlowtimeBreakRatio)
+	if err != nil {
+		return GuildConfig{}, err
+	}
+
+	return GuildConfig{
+		TaskDuration:       taskDuration,
+		BreakDuration:      breakDuration,
+		Reminders:          reminders,
+		LongBreakDuration:  longBreakDuration,
+		LongBreakInterval:  PomodoroLongBreakInterval,
+		Mode:               PomodoroModePomodoro,
+		Enforcement:        EnforcementMuteAndDeafen,
+		FlowtimeBreakRatio: flowtimeBreakRatio,
+		StreakGoal:         PomodoroStreakGoal,
+	}, nil
+}
+
+func (c GuildConfig) Validate() error {
+	if c.TaskDuration <= 0 {
+		return fmt.Errorf("task duration must be positive: %s", c.TaskDuration)
+	}
+	if c.BreakDuration <= 0 {
+		return fmt.Errorf("break duration must be positive: %s", c.BreakDuration)
+	}
+	if c.LongBreakDuration <= 0 {
+		return fmt.Errorf("long break duration must be positive: %s", c.LongBreakDuration)
+	}
+	for _, offsets := range c.Reminders {
+		for _, offset := range offsets {
+			if offset <= 0 {
+				return fmt.Errorf("reminder must be positive: %s", offset)
+			}
+		}
+	}
+	if c.LongBreakInterval < 1 {
+		return fmt.Errorf("long break interval must be at least 1: %d", c.LongBreakInterval)
+	}
+	if c.FlowtimeBreakRatio <= 0 {
+		return fmt.Errorf("flowtime break ratio must be positive: %g", c.FlowtimeBreakRatio)
+	}
+	if c.Cycles < 0 {
+		return fmt.Errorf("cycles must not be negative: %d", c.Cycles)
+	}
+	if c.StreakGoal < 1 {
+		return fmt.Errorf("streak goal must be at least 1: %d", c.StreakGoal)
+	}
+	if _, err := c.BuildSchedule(); err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+	return nil
+}
+
+func (c GuildConfig) BuildSchedule() (Schedule, error) {
+	if len(c.ScheduleSpec) > 0 {
+		return ParseSchedule(c.ScheduleSpec)
+	}
+	return DefaultSchedule(c.TaskDuration, c.BreakDuration, c.LongBreakDuration, c.LongBreakInterval), nil
+}
+
+// /pomodoro config で保存された設定、ギルドの初期設定、デフォルト値の順に探す
+func (b *Bot) GetGuildConfig(guildID GuildID) (GuildConfig, error) {
+	b.guildConfigMapLock.Lock()
+	defer b.guildConfigMapLock.Unlock()
+	if c, ok := b.guildConfigMap[guildID]; ok {
+		c.Reminders = c.Reminders.Copy()
+		return c, nil
+	}
+
+	if saved, ok, err := b.store.LoadGuildConfig(guildID); err != nil {
+		return GuildConfig{}, err
+	} else if ok {
+		c, err := guildConfigFromStorage(saved)
+		if err != nil {
+			return GuildConfig{}, fmt.Errorf("invalid saved config of guild %s: %w", guildID, err)
+		}
+		b.guildConfigMap[guildID] = c
+		c.Reminders = c.Reminders.Copy()
+		return c, nil
+	}
+
+	if c, ok := b.guildDefaultConfigMap[guildID]; ok {
+		c.Reminders = c.Reminders.Copy()
+		return c, nil
+	}
+	return DefaultGuildConfig()
+}
+
+// 設定を保存する
+func (b *Bot) SetGuildConfig(guildID GuildID, c GuildConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	b.guildConfigMapLock.Lock()
+	defer b.guildConfigMapLock.Unlock()
+	if err := b.store.SaveGuildConfig(guildID, c.toStorage()); err != nil {
+		return err
+	}
+	c.Reminders = c.Reminders.Copy()
+	b.guildConfigMap[guildID] = c
+	return nil
+}
+
+// 保存された設定がないときに使う設定をセットする
+// 保存はしない
+func (b *Bot) setGuildDefaultConfig(guildID GuildID, c GuildConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	b.guildConfigMapLock.Lock()
+	defer b.guildConfigMapLock.Unlock()
+	c.Reminders = c.Reminders.Copy()
+	b.guildDefaultConfigMap[guildID] = c
+	return nil
+}
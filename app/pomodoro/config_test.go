@@ -0,0 +1,120 @@
+package pomodoro
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pollenjp/pomodoro-bot/app/clock"
+)
+
+func TestGuildConfigValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		modify func(c *GuildConfig)
+		err    string
+	}{
+		{"default", func(c *GuildConfig) {}, ""},
+		{"zero task", func(c *GuildConfig) { c.TaskDuration = 0 }, "task duration must be positive"},
+		{"negative break", func(c *GuildConfig) { c.BreakDuration = -time.Minute }, "break duration must be positive"},
+		{"zero long break", func(c *GuildConfig) { c.LongBreakDuration = 0 }, "long break duration must be positive"},
+		{"negative long break", func(c *GuildConfig) { c.LongBreakDuration = -time.Minute }, "long break duration must be positive"},
+		{"zero long break interval", func(c *GuildConfig) { c.LongBreakInterval = 0 }, "long break interval must be at least 1"},
+		{"negative reminder", func(c *GuildConfig) { c.Reminders = Reminders{PhaseKindTask: {-time.Minute}} }, "reminder must be positive"},
+		{"zero ratio", func(c *GuildConfig) { c.FlowtimeBreakRatio = 0 }, "flowtime break ratio must be positive"},
+		{"negative cycles", func(c *GuildConfig) { c.Cycles = -1 }, "cycles must not be negative"},
+		{"zero streak goal", func(c *GuildConfig) { c.StreakGoal = 0 }, "streak goal must be at least 1"},
+		{"invalid schedule", func(c *GuildConfig) { c.ScheduleSpec = "25x" }, "invalid schedule"},
+	} {
+		config, err := DefaultGuildConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.modify(&config)
+
+		err = config.Validate()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: Validate() = %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%s: Validate() = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func stringOption(name string, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  name,
+		Type:  discordgo.ApplicationCommandOptionString,
+		Value: value,
+	}
+}
+
+// 更新した設定は GetGuildConfig から読め、次に生成される Pomodoro で使われる
+func TestConfigCommandRoundTrip(t *testing.T) {
+	b := newTestBot(t)
+
+	// オプションがなければ現在の設定を表示する
+	if got := b.configCommand(testGuildID, nil); !strings.HasPrefix(got, "Current config:\n") || !strings.Contains(got, "- task: `25m0s`") {
+		t.Errorf("configCommand() = %q", got)
+	}
+
+	got := b.configCommand(testGuildID, []*discordgo.ApplicationCommandInteractionDataOption{
+		stringOption("task", "50m"),
+		stringOption("break", "10m"),
+	})
+	for _, want := range []string{"Updated config", "- task: `50m0s`", "- break: `10m0s`"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("configCommand(task, break) = %q does not contain %q", got, want)
+		}
+	}
+	config, err := b.GetGuildConfig(testGuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TaskDuration != 50*time.Minute || config.BreakDuration != 10*time.Minute {
+		t.Errorf("task = %s, break = %s", config.TaskDuration, config.BreakDuration)
+	}
+
+	// 不正な値では更新しない
+	for _, tt := range []struct {
+		option *discordgo.ApplicationCommandInteractionDataOption
+		want   string
+	}{
+		{stringOption("task", "50"), "Invalid duration for `task`"},
+		{stringOption("break", "-5m"), "Invalid config:"},
+	} {
+		if got := b.configCommand(testGuildID, []*discordgo.ApplicationCommandInteractionDataOption{tt.option}); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("configCommand(%s=%v) = %q, want %q", tt.option.Name, tt.option.Value, got, tt.want)
+		}
+	}
+	if got, _ := b.GetGuildConfig(testGuildID); got.TaskDuration != 50*time.Minute || got.BreakDuration != 10*time.Minute {
+		t.Errorf("config was changed by invalid options: task = %s, break = %s", got.TaskDuration, got.BreakDuration)
+	}
+
+	guild := testGuildInfo()
+	room, _ := guild.Room(testVoiceChannelID)
+	c := clock.NewFake(time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC))
+	discord := newFakeDiscord()
+	p, err := b.newPomodoro(context.Background(), c, discord, guild, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	tp := &testPomodoro{Pomodoro: p, clock: c, discord: discord}
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.advance(50*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.advance(10*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+}
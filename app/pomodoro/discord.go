@@ -0,0 +1,60 @@
+package pomodoro
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord は bot が使う Discord の操作だけをまとめたもの
+// 本番では discordgo.Session をラップし、テストではメモリ上の fake を使う
+type Discord interface {
+	SendMessage(channelID ChannelID, content string) error
+	SendMessageComplex(channelID ChannelID, data *discordgo.MessageSend) error
+	SendDirectMessage(userID UserID, content string) error
+	SendDirectMessageComplex(userID UserID, data *discordgo.MessageSend) error
+	MuteMember(guildID GuildID, userID UserID, mute bool) error
+	DeafenMember(guildID GuildID, userID UserID, deaf bool) error
+	User(userID UserID) (*discordgo.User, error)
+}
+
+type discordSession struct {
+	session *discordgo.Session
+}
+
+func NewDiscord(session *discordgo.Session) Discord {
+	return &discordSession{session: session}
+}
+
+func (d *discordSession) SendMessage(channelID ChannelID, content string) error {
+	_, err := d.session.ChannelMessageSend(channelID, content)
+	return err
+}
+
+func (d *discordSession) SendMessageComplex(channelID ChannelID, data *discordgo.MessageSend) error {
+	_, err := d.session.ChannelMessageSendComplex(channelID, data)
+	return err
+}
+
+func (d *discordSession) SendDirectMessage(userID UserID, content string) error {
+	return d.SendDirectMessageComplex(userID, &discordgo.MessageSend{Content: content})
+}
+
+func (d *discordSession) SendDirectMessageComplex(userID UserID, data *discordgo.MessageSend) error {
+	channel, err := d.session.UserChannelCreate(userID)
+	if err != nil {
+		return err
+	}
+	_, err = d.session.ChannelMessageSendComplex(channel.ID, data)
+	return err
+}
+
+func (d *discordSession) MuteMember(guildID GuildID, userID UserID, mute bool) error {
+	return d.session.GuildMemberMute(guildID, userID, mute)
+}
+
+func (d *discordSession) DeafenMember(guildID GuildID, userID UserID, deaf bool) error {
+	return d.session.GuildMemberDeafen(guildID, userID, deaf)
+}
+
+func (d *discordSession) User(userID UserID) (*discordgo.User, error) {
+	return d.session.User(userID)
+}
@@ -0,0 +1,167 @@
+package pomodoro
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type fakeMessage struct {
+	ChannelID  ChannelID
+	Content    string
+	Components []discordgo.MessageComponent
+}
+
+type fakeMember struct {
+	guildID GuildID
+	userID  UserID
+}
+
+// fakeDiscord は送ったメッセージとメンバーごとの mute/deafen の状態をメモリ上に記録する
+type fakeDiscord struct {
+	mu       sync.Mutex
+	messages []fakeMessage
+	// ユーザーごとの DM
+	directMessages map[UserID][]string
+	// ユーザーごとに DM で送ったファイルの名前と中身
+	directFiles map[UserID]map[string][]byte
+	muted       map[fakeMember]bool
+	deafened    map[fakeMember]bool
+	// ユーザーごとの mute/deafen を変更した回数
+	voiceChanges map[UserID]int
+	users        map[UserID]*discordgo.User
+	// VC にいないユーザーの mute/deafen は変更できない
+	disconnected map[UserID]bool
+}
+
+var _ Discord = (*fakeDiscord)(nil)
+
+func newFakeDiscord() *fakeDiscord {
+	return &fakeDiscord{
+		directMessages: map[UserID][]string{},
+		directFiles:    map[UserID]map[string][]byte{},
+		muted:          map[fakeMember]bool{},
+		deafened:       map[fakeMember]bool{},
+		voiceChanges:   map[UserID]int{},
+		users:          map[UserID]*discordgo.User{},
+		disconnected:   map[UserID]bool{},
+	}
+}
+
+func (d *fakeDiscord) SendMessage(channelID ChannelID, content string) error {
+	return d.SendMessageComplex(channelID, &discordgo.MessageSend{Content: content})
+}
+
+func (d *fakeDiscord) SendMessageComplex(channelID ChannelID, data *discordgo.MessageSend) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.messages = append(d.messages, fakeMessage{
+		ChannelID:  channelID,
+		Content:    data.Content,
+		Components: data.Components,
+	})
+	return nil
+}
+
+func (d *fakeDiscord) SendDirectMessage(userID UserID, content string) error {
+	return d.SendDirectMessageComplex(userID, &discordgo.MessageSend{Content: content})
+}
+
+func (d *fakeDiscord) SendDirectMessageComplex(userID UserID, data *discordgo.MessageSend) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.directMessages[userID] = append(d.directMessages[userID], data.Content)
+	for _, file := range data.Files {
+		b, err := io.ReadAll(file.Reader)
+		if err != nil {
+			return err
+		}
+		if d.directFiles[userID] == nil {
+			d.directFiles[userID] = map[string][]byte{}
+		}
+		d.directFiles[userID][file.Name] = b
+	}
+	return nil
+}
+
+func (d *fakeDiscord) MuteMember(guildID GuildID, userID UserID, mute bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.disconnected[userID] {
+		return fmt.Errorf("target user is not connected to voice")
+	}
+	d.muted[fakeMember{guildID, userID}] = mute
+	d.voiceChanges[userID]++
+	return nil
+}
+
+func (d *fakeDiscord) DeafenMember(guildID GuildID, userID UserID, deaf bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.disconnected[userID] {
+		return fmt.Errorf("target user is not connected to voice")
+	}
+	d.deafened[fakeMember{guildID, userID}] = deaf
+	d.voiceChanges[userID]++
+	return nil
+}
+
+// 登録されていないユーザーは ID だけを持つユーザーとして返す
+func (d *fakeDiscord) User(userID UserID) (*discordgo.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if user, ok := d.users[userID]; ok {
+		u := *user
+		return &u, nil
+	}
+	return &discordgo.User{ID: userID, Username: userID}, nil
+}
+
+func (d *fakeDiscord) Messages() []fakeMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]fakeMessage{}, d.messages...)
+}
+
+func (d *fakeDiscord) DirectMessages(userID UserID) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.directMessages[userID]...)
+}
+
+func (d *fakeDiscord) DirectFiles(userID UserID) map[string][]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	files := map[string][]byte{}
+	for name, b := range d.directFiles[userID] {
+		files[name] = b
+	}
+	return files
+}
+
+// VC から抜けたことにする (false なら VC に戻る)
+func (d *fakeDiscord) SetDisconnected(userID UserID, disconnected bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.disconnected[userID] = disconnected
+}
+
+func (d *fakeDiscord) VoiceChanges(userID UserID) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.voiceChanges[userID]
+}
+
+func (d *fakeDiscord) MutedAndDeafened(guildID GuildID, userID UserID) (bool, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := fakeMember{guildID, userID}
+	return d.muted[m], d.deafened[m]
+}
+
+func mentions(msg fakeMessage, userID UserID) bool {
+	return strings.Contains(msg.Content, "<@"+userID+">")
+}
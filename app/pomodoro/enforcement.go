@@ -0,0 +1,127 @@
+package pomodoro
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Enforcement はタスク中にメンバーへ何をするか
+// ユーザーの設定、ルームの設定、ギルドの設定の順に優先する
+type Enforcement int
+
+const (
+	// 次に優先する設定に従う
+	EnforcementDefault Enforcement = iota
+	// 通知だけして mute も deafen もしない
+	EnforcementNone
+	EnforcementMute
+	EnforcementDeafen
+	EnforcementMuteAndDeafen
+)
+
+var enforcementNames = map[Enforcement]string{
+	EnforcementDefault:       "default",
+	EnforcementNone:          "none",
+	EnforcementMute:          "mute",
+	EnforcementDeafen:        "deafen",
+	EnforcementMuteAndDeafen: "mute+deafen",
+}
+
+func (e Enforcement) String() string {
+	return enforcementNames[e]
+}
+
+func ParseEnforcement(s string) (Enforcement, error) {
+	for e, name := range enforcementNames {
+		if name == s {
+			return e, nil
+		}
+	}
+	return EnforcementDefault, fmt.Errorf("unknown enforcement: %s", s)
+}
+
+// タスク中に付ける server mute/deafen
+func (e Enforcement) flags() VoiceFlags {
+	switch e {
+	case EnforcementNone:
+		return VoiceFlags{}
+	case EnforcementMute:
+		return VoiceFlags{Mute: true}
+	case EnforcementDeafen:
+		return VoiceFlags{Deaf: true}
+	default:
+		return VoiceFlags{Mute: true, Deaf: true}
+	}
+}
+
+func enforcementChoices(withDefault bool) []*discordgo.ApplicationCommandOptionChoice {
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, e := range []Enforcement{EnforcementDefault, EnforcementNone, EnforcementMute, EnforcementDeafen, EnforcementMuteAndDeafen} {
+		if e == EnforcementDefault && !withDefault {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: e.String(), Value: e.String()})
+	}
+	return choices
+}
+
+// userID に適用する設定
+func (p *Pomodoro) enforcementFor(userID UserID) Enforcement {
+	if prefs, ok, err := p.store.LoadUserPrefs(p.guildID, userID); err != nil {
+		log.Printf("Failed to load prefs of %s: %v", userID, err)
+	} else if ok && prefs.Enforcement != "" {
+		if e, err := ParseEnforcement(prefs.Enforcement); err != nil {
+			log.Printf("Invalid prefs of %s: %v", userID, err)
+		} else if e != EnforcementDefault {
+			return e
+		}
+	}
+	for _, e := range []Enforcement{p.roomEnforcement, p.guildEnforcement} {
+		if e != EnforcementDefault {
+			return e
+		}
+	}
+	return EnforcementMuteAndDeafen
+}
+
+// タスク中で、メンバーに mute/deafen を付けている状態か
+func (p *Pomodoro) focusing() bool {
+	return p.status == PomodoroStatusTask && !p.paused
+}
+
+// メンバーの server mute/deafen を設定に合わせる
+// on が false なら bot が付けたものをすべて外す
+// 参加する前から付いていたものには触らない
+func (p *Pomodoro) enforce(userID UserID, on bool) {
+	want := VoiceFlags{}
+	if on {
+		want = p.enforcementFor(userID).flags()
+	}
+	kept := p.kept[userID]
+	want.Mute = want.Mute && !kept.Mute
+	want.Deaf = want.Deaf && !kept.Deaf
+	p.applied[userID] = setMemberVoice(p.discord, p.store, p.guildID, userID, p.applied[userID], want)
+}
+
+func (p *Pomodoro) enforceAllMembers() {
+	for userID := range p.members {
+		p.enforce(userID, true)
+	}
+}
+
+func (p *Pomodoro) releaseAllMembers() {
+	for userID := range p.members {
+		p.enforce(userID, false)
+	}
+}
+
+// RefreshMember は設定を変えたメンバーの mute/deafen を今のフェーズに合わせ直す
+func (p *Pomodoro) RefreshMember(userID UserID) {
+	p.call(func() {
+		if _, ok := p.members[userID]; ok {
+			p.enforce(userID, p.focusing())
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package pomodoro
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+type PomodoroMode int
+
+const (
+	// スケジュールに従ってタスクと休憩を繰り返す
+	PomodoroModePomodoro PomodoroMode = iota
+	// タスクに終わりの時間がなく、好きなときに休憩に入る
+	// 休憩時間は集中した時間に比例する
+	PomodoroModeFlowtime
+)
+
+const (
+	PomodoroFlowtimeBreakRatio       = "1/5"
+	PomodoroFlowtimeMinBreakDuration = "1m"
+
+	takeBreakButtonCustomID = "pomodoro_take_break"
+)
+
+func (m PomodoroMode) String() string {
+	switch m {
+	case PomodoroModeFlowtime:
+		return "flowtime"
+	default:
+		return "pomodoro"
+	}
+}
+
+func ParsePomodoroMode(s string) (PomodoroMode, error) {
+	switch s {
+	case "pomodoro":
+		return PomodoroModePomodoro, nil
+	case "flowtime":
+		return PomodoroModeFlowtime, nil
+	}
+	return PomodoroModePomodoro, fmt.Errorf("unknown mode: %s", s)
+}
+
+// ParseRatio parses a ratio written either as a fraction ("1/5") or as a
+// decimal ("0.2").
+func ParseRatio(s string) (float64, error) {
+	var ratio float64
+	if numerator, denominator, ok := strings.Cut(s, "/"); ok {
+		n, err := strconv.ParseFloat(strings.TrimSpace(numerator), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid numerator: %s", numerator)
+		}
+		d, err := strconv.ParseFloat(strings.TrimSpace(denominator), 64)
+		if err != nil || d == 0 {
+			return 0, fmt.Errorf("invalid denominator: %s", denominator)
+		}
+		ratio = n / d
+	} else {
+		var err error
+		if ratio, err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+			return 0, fmt.Errorf("invalid ratio: %s", s)
+		}
+	}
+	if ratio <= 0 {
+		return 0, fmt.Errorf("ratio must be positive: %s", s)
+	}
+	return ratio, nil
+}
+
+// 停止中のみ変更できる
+func (p *Pomodoro) SetMode(mode PomodoroMode) error {
+	var err error
+	p.call(func() {
+		if p.status != PomodoroStatusStop {
+			err = fmt.Errorf("cannot change the mode of a running pomodoro")
+			return
+		}
+		p.mode = mode
+	})
+	return err
+}
+
+func (p *Pomodoro) isFlowtimeTask() bool {
+	return p.mode == PomodoroModeFlowtime && p.status == PomodoroStatusTask
+}
+
+// 現在のタスクで集中した時間 (一時停止中の時間は含まない)
+func (p *Pomodoro) focusElapsed() time.Duration {
+	if p.paused {
+		return p.elapsedBeforePause
+	}
+	return p.elapsedBeforePause + p.clock.Now().Sub(p.phaseStartedAt)
+}
+
+// タイマーなしでタスクを開始する
+func (p *Pomodoro) flowTask() {
+	p.status = PomodoroStatusTask
+	p.beginPhase()
+
+	// 休憩のタイマーを止める
+	p.scheduler.reset(p.sessionCtx)
+	p.phaseStartedAt = p.clock.Now()
+	p.elapsedBeforePause = 0
+
+	localizer := p.localizer()
+	msg := ""
+
+	messageID := "Focus as long as you like!"
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
+
+	msg += "\n"
+
+	messageID = "Press the button or run /pomodoro break to take a break."
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
+
+	label := "Take a break"
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: label}); err == nil {
+		label = m
+	}
+
+	log.Print(msg)
+	p.messageWithAllMembersMentionAndStreaks(msg, p.memberStreaks(), []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    label,
+					Style:    discordgo.PrimaryButton,
+					CustomID: takeBreakButtonCustomID,
+				},
+			},
+		},
+	})
+	p.enforceAllMembers()
+}
+
+// 集中した時間に比例した長さの休憩を開始する
+func (p *Pomodoro) flowBreak() {
+	focused := p.focusElapsed()
+	p.lastFocusDuration = focused
+
+	minBreakDuration, err := time.ParseDuration(PomodoroFlowtimeMinBreakDuration)
+	if err != nil {
+		log.Printf("Failed to parse min break duration: %v", err)
+	}
+	breakDuration := time.Duration(float64(focused) * p.flowtimeBreakRatio).Round(time.Second)
+	if breakDuration < minBreakDuration {
+		breakDuration = minBreakDuration
+	}
+	log.Printf("Focused for %s, break for %s", focused, breakDuration)
+
+	p.startBreak(PomodoroStatusBreakTime, breakDuration)
+}
+
+// フロータイムのタスクを終えて休憩に入る
+// タイマーが発火したときと同じ endPhase を通る
+func (p *Pomodoro) TakeBreak() error {
+	var err error
+	p.call(func() {
+		err = p.takeBreak()
+	})
+	return err
+}
+
+func (p *Pomodoro) takeBreak() error {
+	if p.status == PomodoroStatusStop {
+		return fmt.Errorf("pomodoro is not running")
+	}
+	if !p.isFlowtimeTask() {
+		return fmt.Errorf("you can take a break only while focusing in flowtime mode")
+	}
+
+	p.recordPhase(phaseCompleted)
+
+	if p.paused {
+		// 一時停止中の時間を集中した時間に含めない
+		p.paused = false
+		p.phaseStartedAt = p.clock.Now()
+	}
+	p.endPhase()
+	return nil
+}
@@ -0,0 +1,165 @@
+package pomodoro
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func newTestFlowtime(t *testing.T) *testPomodoro {
+	t.Helper()
+	tp := newTestPomodoro(t, "25w 5b")
+	if err := tp.SetMode(PomodoroModeFlowtime); err != nil {
+		t.Fatal(err)
+	}
+	tp.call(func() { tp.flowtimeBreakRatio = 0.2 })
+	return tp
+}
+
+func (tp *testPomodoro) lastMessage(t *testing.T) fakeMessage {
+	t.Helper()
+	messages := tp.discord.Messages()
+	if len(messages) == 0 {
+		t.Fatal("no messages")
+	}
+	return messages[len(messages)-1]
+}
+
+func TestFlowtimeTakeBreak(t *testing.T) {
+	tp := newTestFlowtime(t)
+
+	if err := tp.TakeBreak(); err == nil {
+		t.Error("TakeBreak() before start did not fail")
+	}
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.assertMutedAndDeafened(t, "alice", true)
+	// タスクに終わりの時間はない
+	tp.advance(2 * time.Hour)
+	tp.assertStatus(t, PomodoroStatusTask)
+
+	if err := tp.TakeBreak(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.assertMutedAndDeafened(t, "alice", false)
+	for _, want := range []string{"時間は24分間しかないのんな", "2h0m0s 集中したのん!"} {
+		if msg := tp.lastMessage(t).Content; !strings.Contains(msg, want) {
+			t.Errorf("break start %q does not contain %q", msg, want)
+		}
+	}
+	if err := tp.TakeBreak(); err == nil {
+		t.Error("TakeBreak() during break did not fail")
+	}
+
+	// 休憩は集中した時間の 1/5
+	tp.advance(24*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.assertMutedAndDeafened(t, "alice", true)
+
+	if phases, _ := tp.store.LoadPhases(testGuildID, time.Time{}); len(phases) != 2 || phases[0].Duration != 2*time.Hour || phases[1].Duration != 24*time.Minute {
+		t.Errorf("recorded phases = %+v", phases)
+	}
+}
+
+func TestFlowtimeMinBreak(t *testing.T) {
+	tp := newTestFlowtime(t)
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	// 2 分の 1/5 は 24 秒なので 1 分に切り上げる
+	tp.advance(2 * time.Minute)
+	if err := tp.TakeBreak(); err != nil {
+		t.Fatal(err)
+	}
+	tp.advance(time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+}
+
+func TestFlowtimePauseResume(t *testing.T) {
+	tp := newTestFlowtime(t)
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.advance(10 * time.Minute)
+	if err := tp.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if msg := tp.lastMessage(t).Content; !strings.Contains(msg, "ここまで 10m0s 集中したのん") {
+		t.Errorf("pause message = %q", msg)
+	}
+	// 一時停止中の時間は集中した時間に含めない
+	tp.advance(30 * time.Minute)
+	if err := tp.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	tp.advance(10 * time.Minute)
+	if err := tp.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	tp.advance(time.Hour)
+
+	// 一時停止中でも休憩に入れる
+	if err := tp.TakeBreak(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	if msg := tp.lastMessage(t).Content; !strings.Contains(msg, "20m0s 集中したのん!") {
+		t.Errorf("break start = %q", msg)
+	}
+	tp.advance(4*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+}
+
+func TestFlowtimeTakeBreakButton(t *testing.T) {
+	b := newTestBot(t)
+	config, err := DefaultGuildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Mode = PomodoroModeFlowtime
+	if err := b.SetGuildConfig(testGuildID, config); err != nil {
+		t.Fatal(err)
+	}
+	discord := newFakeDiscord()
+
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", testVoiceChannelID))
+	t.Cleanup(func() {
+		b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", testVoiceChannelID, ""))
+	})
+
+	// タスク開始のメッセージに休憩ボタンを付ける
+	messages := discord.Messages()
+	start := messages[len(messages)-1]
+	if len(start.Components) != 1 {
+		t.Fatalf("components = %+v", start.Components)
+	}
+	button := start.Components[0].(discordgo.ActionsRow).Components[0].(discordgo.Button)
+	if button.CustomID != takeBreakButtonCustomID {
+		t.Errorf("button = %+v", button)
+	}
+	if _, ok := componentHandlers[button.CustomID]; !ok {
+		t.Errorf("no handler for %q", button.CustomID)
+	}
+
+	s := &discordgo.Session{State: discordgo.NewState()}
+	if got := b.takeBreakCommand(s, testGuildInfo(), "bob"); got != "You are not in any pomodoro." {
+		t.Errorf("takeBreakCommand(bob) = %q", got)
+	}
+	if got, want := b.takeBreakCommand(s, testGuildInfo(), "alice"), "Break time! See <#"+testTextChannelID+">!"; got != want {
+		t.Errorf("takeBreakCommand(alice) = %q, want %q", got, want)
+	}
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); mute || deaf {
+		t.Errorf("alice in break: mute = %v, deaf = %v", mute, deaf)
+	}
+	if got := b.takeBreakCommand(s, testGuildInfo(), "alice"); !strings.HasPrefix(got, "Cannot do that:") {
+		t.Errorf("takeBreakCommand(alice) during break = %q", got)
+	}
+}
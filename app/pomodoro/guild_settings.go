@@ -0,0 +1,171 @@
+package pomodoro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ギルド設定ファイルの形式
+//
+//	{
+//	  "guilds": [
+//	    {
+//	      "guild_id": "111111111111111111",
+//	      "notification_channel_id": "111111111111111111",
+//	      "rooms": [
+//	        {"vc_id": "111111111111111111"},
+//	        {"vc_id": "222222222222222222", "notification_channel_id": "222222222222222222", "schedule": "50w 10b", "enforcement": "mute"}
+//	      ],
+//	      "locale": "en",
+//	      "time_zone": "Asia/Tokyo",
+//	      "task": "50m",
+//	      "break": "10m",
+//	      "enforcement": "none",
+//	      "streak_goal": 2
+//	    }
+//	  ]
+//	}
+//
+// VC が 1 つだけなら rooms の代わりに "pomodoro_vc_id" と書いてもよい
+// locale 以降は省略でき、省略した項目はデフォルト値になる
+type guildSettingsFile struct {
+	Guilds []guildSettingsEntry `json:"guilds"`
+}
+
+type guildSettingsEntry struct {
+	GuildID                  string              `json:"guild_id"`
+	ChannelIDForNotification string              `json:"notification_channel_id"`
+	ChannelIDForPomodoroVC   string              `json:"pomodoro_vc_id"`
+	Rooms                    []roomSettingsEntry `json:"rooms"`
+	Locale                   string              `json:"locale"`
+	TimeZone                 string              `json:"time_zone"`
+
+	Task              string `json:"task"`
+	Break             string `json:"break"`
+	LongBreak         string `json:"long_break"`
+	LongBreakInterval int    `json:"long_break_interval"`
+	Schedule          string `json:"schedule"`
+	// "none", "mute", "deafen" or "mute+deafen"
+	Enforcement string `json:"enforcement"`
+	StreakGoal  int    `json:"streak_goal"`
+}
+
+type roomSettingsEntry struct {
+	VoiceChannelID           string `json:"vc_id"`
+	ChannelIDForNotification string `json:"notification_channel_id"`
+	Schedule                 string `json:"schedule"`
+	Enforcement              string `json:"enforcement"`
+}
+
+func (e guildSettingsEntry) rooms() ([]RoomInfo, error) {
+	rooms := []RoomInfo{}
+	if e.ChannelIDForPomodoroVC != "" {
+		rooms = append(rooms, RoomInfo{VoiceChannelID: e.ChannelIDForPomodoroVC})
+	}
+	for _, r := range e.Rooms {
+		room := RoomInfo{
+			VoiceChannelID:           r.VoiceChannelID,
+			ChannelIDForNotification: r.ChannelIDForNotification,
+			Schedule:                 r.Schedule,
+		}
+		if r.Enforcement != "" {
+			enforcement, err := ParseEnforcement(r.Enforcement)
+			if err != nil {
+				return nil, fmt.Errorf("vc %s: %w", r.VoiceChannelID, err)
+			}
+			room.Enforcement = enforcement
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+func (e guildSettingsEntry) hasConfig() bool {
+	return e.Task != "" || e.Break != "" || e.LongBreak != "" || e.LongBreakInterval != 0 || e.Schedule != "" || e.Enforcement != "" || e.StreakGoal != 0
+}
+
+func (e guildSettingsEntry) config() (*GuildConfig, error) {
+	if !e.hasConfig() {
+		return nil, nil
+	}
+
+	config, err := DefaultGuildConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"task", e.Task, &config.TaskDuration},
+		{"break", e.Break, &config.BreakDuration},
+		{"long_break", e.LongBreak, &config.LongBreakDuration},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if *d.dst, err = time.ParseDuration(d.value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+	}
+	if e.LongBreakInterval != 0 {
+		config.LongBreakInterval = e.LongBreakInterval
+	}
+	if e.StreakGoal != 0 {
+		config.StreakGoal = e.StreakGoal
+	}
+	config.ScheduleSpec = e.Schedule
+	if e.Enforcement != "" {
+		if config.Enforcement, err = ParseEnforcement(e.Enforcement); err != nil {
+			return nil, err
+		}
+		if config.Enforcement == EnforcementDefault {
+			return nil, fmt.Errorf("enforcement of a guild must not be %s", EnforcementDefault)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// LoadGuildSettings はギルド設定ファイルを読み込む
+func LoadGuildSettings(r io.Reader) ([]GuildSettings, error) {
+	var file guildSettingsFile
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid guild settings: %w", err)
+	}
+
+	settings := []GuildSettings{}
+	for _, e := range file.Guilds {
+		rooms, err := e.rooms()
+		if err != nil {
+			return nil, fmt.Errorf("guild %s: %w", e.GuildID, err)
+		}
+		g := GuildSettings{
+			GuildInfo: GuildInfo{
+				GuildID:                  e.GuildID,
+				ChannelIDForNotification: e.ChannelIDForNotification,
+				Rooms:                    rooms,
+				Locale:                   e.Locale,
+				TimeZone:                 e.TimeZone,
+			},
+		}
+		if err := g.Validate(); err != nil {
+			return nil, err
+		}
+		config, err := e.config()
+		if err != nil {
+			return nil, fmt.Errorf("guild %s: %w", e.GuildID, err)
+		}
+		g.Config = config
+		settings = append(settings, g)
+	}
+	return settings, nil
+}
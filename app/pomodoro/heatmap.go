@@ -0,0 +1,118 @@
+package pomodoro
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	HeatmapDefaultWeeks = 12
+	HeatmapMaxWeeks     = 53
+
+	heatmapCellSize = 12
+	heatmapCellGap  = 3
+)
+
+var (
+	heatmapBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	// 0 回から多い順に GitHub の contribution graph と同じ色
+	heatmapColors = []color.RGBA{
+		{0xeb, 0xed, 0xf0, 0xff},
+		{0x9b, 0xe9, 0xa8, 0xff},
+		{0x40, 0xc4, 0x63, 0xff},
+		{0x30, 0xa1, 0x4e, 0xff},
+		{0x21, 0x6e, 0x39, 0xff},
+	}
+)
+
+// 一番多い日に対する割合で色を決める
+func heatmapColor(count int, max int) color.RGBA {
+	if count <= 0 || max <= 0 {
+		return heatmapColors[0]
+	}
+	levels := len(heatmapColors) - 1
+	level := (count*levels + max - 1) / max
+	if level > levels {
+		level = levels
+	}
+	return heatmapColors[level]
+}
+
+// 最初の日 (weeks 週前の月曜日)
+func heatmapStart(now time.Time, weeks int) time.Time {
+	return startOfWeek(now).AddDate(0, 0, -7*(weeks-1))
+}
+
+// 列が週 (左が古い)、行が曜日 (上が月曜日) の画像を描く
+// now より後の日は描かない
+func renderHeatmap(daily map[string]int, now time.Time, weeks int) image.Image {
+	size := func(n int) int {
+		return heatmapCellGap + n*(heatmapCellSize+heatmapCellGap)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, size(weeks), size(7)))
+	draw.Draw(img, img.Bounds(), &image.Uniform{heatmapBackground}, image.Point{}, draw.Src)
+
+	start := heatmapStart(now, weeks)
+	today := startOfDay(now)
+	// 描く日だけで一番多い日を探す
+	days := []time.Time{}
+	max := 0
+	for day := start; !day.After(today); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+		if count := daily[day.Format(streakDateLayout)]; count > max {
+			max = count
+		}
+	}
+
+	for i, day := range days {
+		// 週の数と曜日からセルの左上の位置を決める
+		x, y := size(i/7), size(i%7)
+		cell := image.Rect(x, y, x+heatmapCellSize, y+heatmapCellSize)
+		c := heatmapColor(daily[day.Format(streakDateLayout)], max)
+		draw.Draw(img, cell, &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+// user が最近 weeks 週に完了したポモドーロのヒートマップを PNG で返す
+// 失敗したときはメッセージだけを返す
+func (b *Bot) heatmapCommand(guild GuildInfo, user *discordgo.User, weeks int) (*discordgo.File, string) {
+	loc := guild.location()
+	if prefs, ok, err := b.store.LoadUserPrefs(guild.GuildID, user.ID); err != nil {
+		log.Printf("Failed to load prefs of %s: %v", user.ID, err)
+	} else if ok {
+		loc = userLocation(loc, prefs)
+	}
+	now := time.Now().In(loc)
+
+	records, err := b.store.LoadPhases(guild.GuildID, heatmapStart(now, weeks))
+	if err != nil {
+		log.Printf("Failed to load phases of guild (%s): %v", guild.GuildID, err)
+		return nil, "Failed to load the history."
+	}
+	daily := dailyCompleted(records, user.ID, loc)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, renderHeatmap(daily, now, weeks)); err != nil {
+		log.Printf("Failed to encode heatmap: %v", err)
+		return nil, "Failed to render the heatmap."
+	}
+
+	total := 0
+	for _, count := range daily {
+		total += count
+	}
+	return &discordgo.File{
+		Name:        "heatmap.png",
+		ContentType: "image/png",
+		Reader:      &buf,
+	}, fmt.Sprintf("%s completed %d pomodoros in the last %d weeks.", user.Mention(), total, weeks)
+}
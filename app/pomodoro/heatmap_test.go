@@ -0,0 +1,78 @@
+package pomodoro
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestRenderHeatmap(t *testing.T) {
+	// 2022-10-05 は水曜日
+	now := oct(5, 12)
+	daily := map[string]int{
+		"2022-09-26": 4,
+		"2022-10-03": 1,
+		"2022-10-05": 2,
+		// 範囲外
+		"2022-09-25": 10,
+	}
+	img := renderHeatmap(daily, now, 2)
+
+	cellAt := func(week int, weekday int) color.Color {
+		x := heatmapCellGap + week*(heatmapCellSize+heatmapCellGap) + heatmapCellSize/2
+		y := heatmapCellGap + weekday*(heatmapCellSize+heatmapCellGap) + heatmapCellSize/2
+		return img.At(x, y)
+	}
+	equal := func(a, b color.Color) bool {
+		r1, g1, b1, a1 := a.RGBA()
+		r2, g2, b2, a2 := b.RGBA()
+		return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+	}
+
+	if got, want := img.Bounds().Size(), (image.Point{X: 33, Y: 108}); got != want {
+		t.Errorf("size = %v, want %v", got, want)
+	}
+	// 範囲外の 10 回は一番多い日に含めない
+	for _, tt := range []struct {
+		name          string
+		week, weekday int
+		want          color.Color
+	}{
+		{"09-26", 0, 0, heatmapColors[4]},
+		{"09-27", 0, 1, heatmapColors[0]},
+		{"10-03", 1, 0, heatmapColors[1]},
+		{"10-05", 1, 2, heatmapColors[2]},
+		{"10-06 (future)", 1, 3, heatmapBackground},
+	} {
+		if got := cellAt(tt.week, tt.weekday); !equal(got, tt.want) {
+			t.Errorf("%s: color = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHeatmapCommand(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+	if err := store.AppendPhase(completedTask("alice", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	file, content := b.heatmapCommand(testGuildInfo(), &discordgo.User{ID: "alice"}, 4)
+	if file == nil {
+		t.Fatalf("no file: %s", content)
+	}
+	if content != "<@alice> completed 1 pomodoros in the last 4 weeks." {
+		t.Errorf("content = %q", content)
+	}
+	img, err := png.Decode(file.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Dx(); got != heatmapCellGap+4*(heatmapCellSize+heatmapCellGap) {
+		t.Errorf("width = %d", got)
+	}
+}
@@ -8,25 +8,19 @@ import (
 	"golang.org/x/text/language"
 )
 
-func init() {
-	initI18n()
-}
-
 type i18nTemplate struct {
 	msg map[string]any
 }
 
-var (
-	I18nBundle *i18n.Bundle
-)
-
-func initI18n() {
-	I18nBundle = i18n.NewBundle(language.Japanese)
-	I18nBundle.RegisterUnmarshalFunc("json", json.Unmarshal)
+// Bot ごとに作る
+// テンプレートの言語ごとにメッセージが揃っていなければ panic する
+func newI18nBundle() *i18n.Bundle {
+	bundle := i18n.NewBundle(language.Japanese)
+	bundle.RegisterUnmarshalFunc("json", json.Unmarshal)
 
 	parse := func(lang language.Tag, t i18nTemplate) {
 		if msg, err := json.Marshal(t.msg); err == nil {
-			I18nBundle.MustParseMessageFileBytes(msg, lang.String()+".json")
+			bundle.MustParseMessageFileBytes(msg, lang.String()+".json")
 		}
 	}
 
@@ -36,18 +30,60 @@ func initI18n() {
 				// task
 				"Start your task!": "Start your task!",
 				"Task will end in Min minutes.": map[string]interface{}{
-					"one":   "The break will end in {{ .Min }} minute.",
-					"other": "The break will end in {{ .Min }} minutes.",
+					"one":   "The task will end in {{ .Min }} minute.",
+					"other": "The task will end in {{ .Min }} minutes.",
 				},
-				"The task will end at DateTime.": "The task will end at DateTime.",
+				"The task will end at DateTime.": "The task will end at {{ .DateTime }}.",
+				"The task will end soon!":        "The task will end soon! ({{ .Duration }} later)",
 				// break
 				"The break has started!": "Pomodoro break time has started!",
 				"The break will end in Min minutes.": map[string]interface{}{
 					"one":   "The break will end in {{ .Min }} minute.",
 					"other": "The break will end in {{ .Min }} minutes.",
 				},
-				"The break will end at DateTime.": "The break will end at DateTime.",
+				"The break will end at DateTime.": "The break will end at {{ .DateTime }}.",
 				"The break time will end soon!":   "The break time will end soon! ({{ .Duration }} later)",
+				// long break
+				"The long break has started!": "Pomodoro long break time has started!",
+				"The long break will end in Min minutes.": map[string]interface{}{
+					"one":   "The long break will end in {{ .Min }} minute.",
+					"other": "The long break will end in {{ .Min }} minutes.",
+				},
+				"The long break will end at DateTime.": "The long break will end at {{ .DateTime }}.",
+				"Count/Interval until long break":      "{{ .Count }}/{{ .Interval }} until long break",
+				"The long break will end soon!":        "The long break will end soon! ({{ .Duration }} later)",
+				// pause
+				"Paused. Duration left.":                   "Pomodoro paused! {{ .Duration }} left in this phase.",
+				"Resumed. The phase will end at DateTime.": "Pomodoro resumed! This phase will end at {{ .DateTime }}.",
+				// schedule
+				"The schedule has finished!": "The schedule has finished! Good job!",
+				"Completed Count tasks.": map[string]interface{}{
+					"one":   "You completed {{ .Count }} task.",
+					"other": "You completed {{ .Count }} tasks.",
+				},
+				// flowtime
+				"Focus as long as you like!":                               "Flowtime! Focus as long as you like.",
+				"Press the button or run /pomodoro break to take a break.": "Press the button or run `/pomodoro break` to take a break.",
+				"Take a break":                             "Take a break",
+				"You focused for Duration.":                "You focused for {{ .Duration }}.",
+				"Paused. Duration focused.":                "Pomodoro paused! You have focused for {{ .Duration }} in this phase.",
+				"Resumed. Take a break whenever you like.": "Pomodoro resumed! Take a break whenever you like.",
+				// skip / extend
+				"Skipped the current phase!": "Skipped the current phase!",
+				"Extended by Min minutes. The phase will end at DateTime.": map[string]interface{}{
+					"one":   "Extended by {{ .Min }} minute! This phase will end at {{ .DateTime }}.",
+					"other": "Extended by {{ .Min }} minutes! This phase will end at {{ .DateTime }}.",
+				},
+				// restart
+				"Restored the pomodoro after a restart.":          "The bot restarted, so the pomodoro has been restored.",
+				"The phase will end at DateTime.":                 "This phase will end at {{ .DateTime }}.",
+				"The pomodoro stopped while the bot was offline.": "The phase ended while the bot was offline, so the pomodoro has been stopped.",
+				"The bot is restarting.":                          "The bot is restarting, so the pomodoro has been interrupted and everyone has been un-muted.",
+				// release
+				"Released your server mute and deafen.": "You left a pomodoro voice channel while muted, so your server mute and deafen have been released now.",
+				// streak
+				"Days-day streak":         "🔥 {{ .Days }}-day streak",
+				"Your streak ends today.": "Your {{ .Days }}-day pomodoro streak ends today. Complete {{ .Left }} more today to keep it!",
 			},
 		},
 		language.Japanese: {
@@ -56,11 +92,44 @@ func initI18n() {
 				"Start your task!":               "タスク開始なのん! ╲(๑˙Δ˙๑)",
 				"Task will end in Min minutes.":  "タスクは{{ .Min }}分間ですん!",
 				"The task will end at DateTime.": "{{ .DateTime }} まで頑張るのん!\n" + "https://i.gyazo.com/c5e4b87ff10276499a02c164ea58dc96.png",
+				"The task will end soon!":        "あと {{ .Duration }} でタスクが終わるのん! ラストスパートなのん ٩( 'ω' )و",
 				// break
 				"The break has started!":             "休憩なのん ฅ(๑¯Δ¯๑)",
-				"The break will end in Min minutes.": "時間は五分間しかないのんな ＿(　　＿‾ω‾ )＿",
+				"The break will end in Min minutes.": "時間は{{ .Min }}分間しかないのんな ＿(　　＿‾ω‾ )＿",
 				"The break will end at DateTime.":    "時間は {{ .DateTime }} までなのん c⌒っ＿ω＿)っ\n" + "https://i.gyazo.com/400a0d826b71bccbabf8e92236ef5b4f.png",
 				"The break time will end soon!":      "あと {{ .Duration }} で休憩時間が終わるのんな (　´･ω･)σ",
+				// long break
+				"The long break has started!":             "長い休憩なのん ₍₍ ◝(●˙꒳˙●)◜ ₎₎",
+				"The long break will end in Min minutes.": "長い休憩は{{ .Min }}分間なのん!",
+				"The long break will end at DateTime.":    "時間は {{ .DateTime }} までなのん c⌒っ＿ω＿)っ",
+				"Count/Interval until long break":         "長い休憩まで {{ .Count }}/{{ .Interval }} なのん",
+				"The long break will end soon!":           "あと {{ .Duration }} で長い休憩が終わるのんな (　´･ω･)σ",
+				// pause
+				"Paused. Duration left.":                   "一時停止なのん (｡•ω•｡) 残りは {{ .Duration }} なのん",
+				"Resumed. The phase will end at DateTime.": "再開なのん! {{ .DateTime }} までなのん",
+				// schedule
+				"The schedule has finished!": "スケジュール終了なのん! お疲れさまなのん ( ´ ▽ ` )ﾉ",
+				"Completed Count tasks.":     "今回は{{ .Count }}回タスクをこなしたのん!",
+				// flowtime
+				"Focus as long as you like!":                               "フロータイムなのん! 好きなだけ集中するのん ╲(๑˙Δ˙๑)",
+				"Press the button or run /pomodoro break to take a break.": "休憩したくなったらボタンか `/pomodoro break` なのん",
+				"Take a break":                             "休憩する",
+				"You focused for Duration.":                "{{ .Duration }} 集中したのん!",
+				"Paused. Duration focused.":                "一時停止なのん (｡•ω•｡) ここまで {{ .Duration }} 集中したのん",
+				"Resumed. Take a break whenever you like.": "再開なのん! 好きなときに休憩するのん",
+				// skip / extend
+				"Skipped the current phase!":                               "次に進むのん! ≡≡≡ヘ(*--)ノ",
+				"Extended by Min minutes. The phase will end at DateTime.": "{{ .Min }}分延長なのん! {{ .DateTime }} までなのん",
+				// restart
+				"Restored the pomodoro after a restart.":          "再起動したのでポモドーロを再開するのん ヾ(・ω・)ﾉ",
+				"The phase will end at DateTime.":                 "{{ .DateTime }} までなのん",
+				"The pomodoro stopped while the bot was offline.": "止まっている間にフェーズが終わったのでポモドーロを終了するのん",
+				"The bot is restarting.":                          "再起動するのでポモドーロを中断して、みんなのミュートを解除するのん",
+				// release
+				"Released your server mute and deafen.": "ミュートのまま VC を抜けていたので、サーバーミュートとスピーカーミュートを解除したのん",
+				// streak
+				"Days-day streak":         "🔥 {{ .Days }}日連続",
+				"Your streak ends today.": "{{ .Days }}日連続のポモドーロが今日で途切れそうなのん! 今日あと {{ .Left }} 回終えると続くのん",
 			},
 		},
 	}
@@ -107,4 +176,5 @@ func initI18n() {
 	}
 	assertI18nTemplateMissing(I18nTemplates)
 
+	return bundle
 }
@@ -1,46 +1,153 @@
 package pomodoro
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
 
-var (
-	Info info
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
 )
 
-type info struct {
-	guildID                  GuildID
-	channelIDForNotification ChannelID
-	channelIDForPomodoroVC   ChannelID
+// GuildInfo はギルドごとの Discord 上の設定
+// 設定されていないギルドのイベントやコマンドは無視する
+type GuildInfo struct {
+	GuildID GuildID
+	// ルームに通知チャンネルが設定されていないときに使う
+	ChannelIDForNotification ChannelID
+	// ポモドーロを行う VC
+	Rooms []RoomInfo
+	// メッセージの言語 (e.g. "ja", "en")
+	// 空の場合は日本語
+	Locale string
+	// 統計で日や週の区切りに使うタイムゾーン (e.g. "Asia/Tokyo")
+	// 空の場合は TZ 環境変数のタイムゾーン
+	TimeZone string
 }
 
-func InitInfo(
-	guildID string,
-	channelIDForNotification string,
-	channelIDForPomodoroVC string,
-) {
-	Info = info{
-		guildID:                  guildID,
-		channelIDForNotification: channelIDForNotification,
-		channelIDForPomodoroVC:   channelIDForPomodoroVC,
+// RoomInfo はポモドーロを行う VC ごとの設定
+// VC ごとに独立した Pomodoro が動く
+type RoomInfo struct {
+	VoiceChannelID ChannelID
+	// 空の場合はギルドの ChannelIDForNotification
+	ChannelIDForNotification ChannelID
+	// ParseSchedule で解釈できる文字列
+	// 空の場合はギルドの設定から作る
+	Schedule string
+	// EnforcementDefault ならギルドの設定に従う
+	Enforcement Enforcement
+}
+
+func (g GuildInfo) Validate() error {
+	if g.GuildID == "" {
+		return fmt.Errorf("no guild id exists")
+	}
+	if len(g.Rooms) == 0 {
+		return fmt.Errorf("guild %s: no pomodoro vc exists", g.GuildID)
+	}
+	seen := map[ChannelID]bool{}
+	for _, room := range g.Rooms {
+		if room.VoiceChannelID == "" {
+			return fmt.Errorf("guild %s: no channel id for pomodoro vc exists", g.GuildID)
+		}
+		if seen[room.VoiceChannelID] {
+			return fmt.Errorf("guild %s: pomodoro vc %s is configured twice", g.GuildID, room.VoiceChannelID)
+		}
+		seen[room.VoiceChannelID] = true
+		if room.ChannelIDForNotification == "" && g.ChannelIDForNotification == "" {
+			return fmt.Errorf("guild %s: no channel id for notification exists for vc %s", g.GuildID, room.VoiceChannelID)
+		}
+		if room.Schedule != "" {
+			if _, err := ParseSchedule(room.Schedule); err != nil {
+				return fmt.Errorf("guild %s: invalid schedule for vc %s: %w", g.GuildID, room.VoiceChannelID, err)
+			}
+		}
+	}
+	if g.Locale != "" {
+		if _, err := language.Parse(g.Locale); err != nil {
+			return fmt.Errorf("guild %s: invalid locale %q: %w", g.GuildID, g.Locale, err)
+		}
+	}
+	if g.TimeZone != "" {
+		if _, err := time.LoadLocation(g.TimeZone); err != nil {
+			return fmt.Errorf("guild %s: invalid time zone %q: %w", g.GuildID, g.TimeZone, err)
+		}
+	}
+	return nil
+}
+
+// Room は voiceChannelID がポモドーロ用の VC であればその設定を返す
+// 通知チャンネルが空の場合はギルドの通知チャンネルで埋める
+func (g GuildInfo) Room(voiceChannelID ChannelID) (RoomInfo, bool) {
+	if voiceChannelID == "" {
+		return RoomInfo{}, false
+	}
+	for _, room := range g.Rooms {
+		if room.VoiceChannelID != voiceChannelID {
+			continue
+		}
+		if room.ChannelIDForNotification == "" {
+			room.ChannelIDForNotification = g.ChannelIDForNotification
+		}
+		return room, true
 	}
+	return RoomInfo{}, false
 }
 
-func (i *info) GetGuildID() GuildID {
-	if len(i.guildID) == 0 {
-		log.Fatal("no guild id exists.")
+func (g GuildInfo) location() *time.Location {
+	if g.TimeZone == "" {
+		return time.Local
 	}
-	return i.guildID
+	loc, err := time.LoadLocation(g.TimeZone)
+	if err != nil {
+		// Validate で確認しているので起きないはず
+		log.Printf("Failed to load time zone of guild (%s): %v", g.GuildID, err)
+		return time.Local
+	}
+	return loc
+}
+
+func (g GuildInfo) localizer(bundle *i18n.Bundle) *i18n.Localizer {
+	return i18n.NewLocalizer(bundle, g.Locale, language.Japanese.String())
 }
 
-func (i *info) GetChannelIDForNotification() ChannelID {
-	if len(i.channelIDForNotification) == 0 {
-		log.Fatal("no channel id for notification exists.")
+// SetGuildInfos は設定されたギルドをすべて置き換える
+func (b *Bot) SetGuildInfos(infos []GuildInfo) error {
+	m := make(map[GuildID]GuildInfo, len(infos))
+	for _, g := range infos {
+		if err := g.Validate(); err != nil {
+			return err
+		}
+		if _, ok := m[g.GuildID]; ok {
+			return fmt.Errorf("guild %s is configured twice", g.GuildID)
+		}
+		m[g.GuildID] = g
 	}
-	return i.channelIDForNotification
+
+	b.guildInfoMapLock.Lock()
+	defer b.guildInfoMapLock.Unlock()
+	b.guildInfoMap = m
+	return nil
+}
+
+func (b *Bot) LookupGuildInfo(guildID GuildID) (GuildInfo, bool) {
+	b.guildInfoMapLock.Lock()
+	defer b.guildInfoMapLock.Unlock()
+	g, ok := b.guildInfoMap[guildID]
+	return g, ok
 }
 
-func (i *info) GetChannelIDForPomodoroVC() ChannelID {
-	if len(i.channelIDForPomodoroVC) == 0 {
-		log.Fatal("no channel id for notification exists.")
+// 設定されたすべてのギルドを ID 順に返す
+func (b *Bot) guildInfos() []GuildInfo {
+	b.guildInfoMapLock.Lock()
+	defer b.guildInfoMapLock.Unlock()
+	infos := []GuildInfo{}
+	for _, g := range b.guildInfoMap {
+		infos = append(infos, g)
 	}
-	return i.channelIDForPomodoroVC
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].GuildID < infos[j].GuildID
+	})
+	return infos
 }
@@ -0,0 +1,185 @@
+package pomodoro
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+const (
+	// ボタンの CustomID は "pomodoro_leaderboard:<period>:<page>"
+	leaderboardButtonCustomID = "pomodoro_leaderboard"
+	leaderboardPageSize       = 10
+)
+
+type LeaderboardPeriod string
+
+const (
+	LeaderboardPeriodDay   LeaderboardPeriod = "day"
+	LeaderboardPeriodWeek  LeaderboardPeriod = "week"
+	LeaderboardPeriodMonth LeaderboardPeriod = "month"
+	LeaderboardPeriodAll   LeaderboardPeriod = "all"
+)
+
+var leaderboardPeriodChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "today", Value: string(LeaderboardPeriodDay)},
+	{Name: "this week", Value: string(LeaderboardPeriodWeek)},
+	{Name: "this month", Value: string(LeaderboardPeriodMonth)},
+	{Name: "all time", Value: string(LeaderboardPeriodAll)},
+}
+
+func ParseLeaderboardPeriod(s string) (LeaderboardPeriod, error) {
+	switch p := LeaderboardPeriod(s); p {
+	case LeaderboardPeriodDay, LeaderboardPeriodWeek, LeaderboardPeriodMonth, LeaderboardPeriodAll:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown period: %s", s)
+}
+
+// 期間の始まり (now のタイムゾーン)
+// LeaderboardPeriodAll ならゼロ値
+func (p LeaderboardPeriod) since(now time.Time) time.Time {
+	switch p {
+	case LeaderboardPeriodDay:
+		return startOfDay(now)
+	case LeaderboardPeriodWeek:
+		return startOfWeek(now)
+	case LeaderboardPeriodMonth:
+		y, m, _ := now.Date()
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Time{}
+}
+
+func (p LeaderboardPeriod) title() string {
+	for _, c := range leaderboardPeriodChoices {
+		if c.Value == string(p) {
+			return c.Name
+		}
+	}
+	return string(p)
+}
+
+type leaderboardEntry struct {
+	UserID UserID
+	UserStats
+}
+
+// 完了したポモドーロの数、集中した時間の順に並べる
+func rankLeaderboard(records []storage.PhaseRecord) []leaderboardEntry {
+	stats := map[UserID]*UserStats{}
+	eachTaskParticipant(records, func(_ storage.PhaseRecord, p storage.Participant) {
+		s, ok := stats[p.UserID]
+		if !ok {
+			s = &UserStats{}
+			stats[p.UserID] = s
+		}
+		s.add(p)
+	})
+
+	entries := []leaderboardEntry{}
+	for userID, s := range stats {
+		entries = append(entries, leaderboardEntry{UserID: userID, UserStats: *s})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Completed != b.Completed {
+			return a.Completed > b.Completed
+		}
+		if a.Focused != b.Focused {
+			return a.Focused > b.Focused
+		}
+		return a.UserID < b.UserID
+	})
+	return entries
+}
+
+// ページ数 (エントリがなくても 1)
+func leaderboardPages(entries []leaderboardEntry) int {
+	if len(entries) == 0 {
+		return 1
+	}
+	return (len(entries) + leaderboardPageSize - 1) / leaderboardPageSize
+}
+
+func leaderboardEmbed(entries []leaderboardEntry, period LeaderboardPeriod, page int) *discordgo.MessageEmbed {
+	lines := []string{}
+	for i := page * leaderboardPageSize; i < len(entries) && i < (page+1)*leaderboardPageSize; i++ {
+		e := entries[i]
+		lines = append(lines, fmt.Sprintf("%d. <@%s> %d pomodoros, %d min", i+1, e.UserID, e.Completed, int(e.Focused.Minutes())))
+	}
+	description := strings.Join(lines, "\n")
+	if len(lines) == 0 {
+		description = "No pomodoros yet."
+	}
+	return &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Leaderboard (%s)", period.title()),
+		Description: description,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page %d/%d", page+1, leaderboardPages(entries)),
+		},
+	}
+}
+
+func leaderboardButtons(entries []leaderboardEntry, period LeaderboardPeriod, page int) []discordgo.MessageComponent {
+	button := func(label string, to int, disabled bool) discordgo.Button {
+		return discordgo.Button{
+			Label:    label,
+			Style:    discordgo.SecondaryButton,
+			CustomID: fmt.Sprintf("%s:%s:%d", leaderboardButtonCustomID, period, to),
+			Disabled: disabled,
+		}
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				button("Prev", page-1, page <= 0),
+				button("Next", page+1, page+1 >= leaderboardPages(entries)),
+			},
+		},
+	}
+}
+
+// ギルドのリーダーボードの page ページ目を返す
+// 失敗したときはメッセージを返す
+func (b *Bot) leaderboardCommand(guild GuildInfo, period LeaderboardPeriod, page int) (*discordgo.MessageEmbed, []discordgo.MessageComponent, string) {
+	since := period.since(time.Now().In(guild.location()))
+	records, err := b.store.LoadPhases(guild.GuildID, since)
+	if err != nil {
+		log.Printf("Failed to load phases of guild (%s): %v", guild.GuildID, err)
+		return nil, nil, "Failed to load the history."
+	}
+	entries := rankLeaderboard(records)
+
+	// ボタンを押す間に記録が変わってページが減ることがある
+	if last := leaderboardPages(entries) - 1; page > last {
+		page = last
+	}
+	if page < 0 {
+		page = 0
+	}
+	return leaderboardEmbed(entries, period, page), leaderboardButtons(entries, period, page), ""
+}
+
+// "<period>:<page>" を解釈する
+func parseLeaderboardButtonArgs(args string) (LeaderboardPeriod, int, error) {
+	periodStr, pageStr, ok := strings.Cut(args, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("invalid leaderboard button: %s", args)
+	}
+	period, err := ParseLeaderboardPeriod(periodStr)
+	if err != nil {
+		return "", 0, err
+	}
+	page, err := strconv.Atoi(pageStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid page: %s", pageStr)
+	}
+	return period, page, nil
+}
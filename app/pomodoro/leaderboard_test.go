@@ -0,0 +1,124 @@
+package pomodoro
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+func TestRankLeaderboard(t *testing.T) {
+	task := func(participants ...storage.Participant) storage.PhaseRecord {
+		return storage.PhaseRecord{Phase: storage.PhaseTask, Participants: participants}
+	}
+	records := []storage.PhaseRecord{
+		task(
+			storage.Participant{UserID: "alice", Focused: 25 * time.Minute, Completed: true},
+			storage.Participant{UserID: "bob", Focused: 25 * time.Minute, Completed: true},
+			storage.Participant{UserID: "carol", Focused: 10 * time.Minute, Left: true},
+		),
+		task(
+			storage.Participant{UserID: "bob", Focused: 25 * time.Minute, Completed: true},
+			storage.Participant{UserID: "alice", Focused: 20 * time.Minute},
+			storage.Participant{UserID: "dave", Focused: 20 * time.Minute},
+		),
+		// 休憩は数えない
+		{Phase: storage.PhaseBreak, Participants: []storage.Participant{{UserID: "carol", Focused: time.Hour, Completed: true}}},
+	}
+
+	got := rankLeaderboard(records)
+	want := []leaderboardEntry{
+		{UserID: "bob", UserStats: UserStats{Completed: 2, Focused: 50 * time.Minute}},
+		{UserID: "alice", UserStats: UserStats{Completed: 1, Focused: 45 * time.Minute}},
+		// 同じならユーザー ID の順
+		{UserID: "dave", UserStats: UserStats{Focused: 20 * time.Minute}},
+		{UserID: "carol", UserStats: UserStats{Focused: 10 * time.Minute}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rankLeaderboard() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLeaderboardPeriodSince(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	// UTC では 2024-05-31 (金) 16:00 だが、東京では 2024-06-01 (土) 01:00
+	now := time.Date(2024, 5, 31, 16, 0, 0, 0, time.UTC).In(tokyo)
+
+	for _, tt := range []struct {
+		period LeaderboardPeriod
+		want   time.Time
+	}{
+		{LeaderboardPeriodDay, time.Date(2024, 6, 1, 0, 0, 0, 0, tokyo)},
+		{LeaderboardPeriodWeek, time.Date(2024, 5, 27, 0, 0, 0, 0, tokyo)},
+		{LeaderboardPeriodMonth, time.Date(2024, 6, 1, 0, 0, 0, 0, tokyo)},
+		{LeaderboardPeriodAll, time.Time{}},
+	} {
+		if got := tt.period.since(now); !got.Equal(tt.want) {
+			t.Errorf("%s: since = %s, want %s", tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestLeaderboardCommand(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+
+	participants := []storage.Participant{}
+	for i := 0; i < leaderboardPageSize+2; i++ {
+		participants = append(participants, storage.Participant{UserID: UserID(rune('a' + i)), Focused: time.Duration(i) * time.Minute})
+	}
+	if err := store.AppendPhase(storage.PhaseRecord{
+		GuildID:      testGuildID,
+		Phase:        storage.PhaseTask,
+		EndedAt:      time.Now(),
+		Participants: participants,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	buttons := func(components []discordgo.MessageComponent) []discordgo.Button {
+		row := components[0].(discordgo.ActionsRow)
+		return []discordgo.Button{row.Components[0].(discordgo.Button), row.Components[1].(discordgo.Button)}
+	}
+
+	embed, components, content := b.leaderboardCommand(testGuildInfo(), LeaderboardPeriodAll, 0)
+	if embed == nil {
+		t.Fatalf("no embed: %s", content)
+	}
+	if embed.Footer.Text != "Page 1/2" {
+		t.Errorf("footer = %q", embed.Footer.Text)
+	}
+	prev, next := buttons(components)[0], buttons(components)[1]
+	if !prev.Disabled || next.Disabled {
+		t.Errorf("prev disabled = %v, next disabled = %v", prev.Disabled, next.Disabled)
+	}
+
+	// Next ボタンの CustomID から次のページを開く
+	period, page, err := parseLeaderboardButtonArgs(next.CustomID[len(leaderboardButtonCustomID)+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if period != LeaderboardPeriodAll || page != 1 {
+		t.Fatalf("period = %s, page = %d", period, page)
+	}
+	embed, components, _ = b.leaderboardCommand(testGuildInfo(), period, page)
+	if embed.Footer.Text != "Page 2/2" {
+		t.Errorf("footer = %q", embed.Footer.Text)
+	}
+	// 2 ページ目は 11 位と 12 位
+	if want := "11. <@b> 0 pomodoros, 1 min\n12. <@a> 0 pomodoros, 0 min"; embed.Description != want {
+		t.Errorf("description = %q, want %q", embed.Description, want)
+	}
+	prev, next = buttons(components)[0], buttons(components)[1]
+	if prev.Disabled || !next.Disabled {
+		t.Errorf("prev disabled = %v, next disabled = %v", prev.Disabled, next.Disabled)
+	}
+
+	// 範囲外のページは最後のページにする
+	embed, _, _ = b.leaderboardCommand(testGuildInfo(), period, 5)
+	if embed.Footer.Text != "Page 2/2" {
+		t.Errorf("footer = %q", embed.Footer.Text)
+	}
+}
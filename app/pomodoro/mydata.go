@@ -0,0 +1,217 @@
+package pomodoro
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+// ボタンの CustomID は "pomodoro_mydata_delete:<userID>"
+const mydataDeleteButtonCustomID = "pomodoro_mydata_delete"
+
+// userDataExport は /pomodoro mydata export で書き出すデータ
+// 他のメンバーの情報は含めない
+type userDataExport struct {
+	GuildID     string             `json:"guild_id"`
+	UserID      string             `json:"user_id"`
+	ExportedAt  time.Time          `json:"exported_at"`
+	Preferences *storage.UserPrefs `json:"preferences"`
+	// 解除できていない mute/deafen
+	PendingRelease *storage.PendingRelease `json:"pending_release"`
+	// 今参加しているセッション
+	Sessions []userSessionExport `json:"sessions"`
+	History  []userPhaseExport   `json:"history"`
+}
+
+type userSessionExport struct {
+	VoiceChannelID string         `json:"voice_channel_id"`
+	Phase          string         `json:"phase"`
+	Member         storage.Member `json:"member"`
+}
+
+type userPhaseExport struct {
+	VoiceChannelID  string    `json:"voice_channel_id"`
+	Phase           string    `json:"phase"`
+	StartedAt       time.Time `json:"started_at"`
+	EndedAt         time.Time `json:"ended_at"`
+	DurationSeconds int64     `json:"duration_seconds"`
+	FocusedSeconds  int64     `json:"focused_seconds"`
+	Completed       bool      `json:"completed"`
+	Left            bool      `json:"left"`
+	Skipped         bool      `json:"skipped"`
+	Stopped         bool      `json:"stopped"`
+}
+
+var userPhaseCSVHeader = []string{
+	"voice_channel_id", "phase", "started_at", "ended_at", "duration_seconds", "focused_seconds", "completed", "left", "skipped", "stopped",
+}
+
+func (e userPhaseExport) csvRecord() []string {
+	return []string{
+		e.VoiceChannelID,
+		e.Phase,
+		e.StartedAt.Format(time.RFC3339),
+		e.EndedAt.Format(time.RFC3339),
+		strconv.FormatInt(e.DurationSeconds, 10),
+		strconv.FormatInt(e.FocusedSeconds, 10),
+		strconv.FormatBool(e.Completed),
+		strconv.FormatBool(e.Left),
+		strconv.FormatBool(e.Skipped),
+		strconv.FormatBool(e.Stopped),
+	}
+}
+
+func collectUserData(store storage.Store, guildID GuildID, userID UserID, now time.Time) (userDataExport, error) {
+	data := userDataExport{
+		GuildID:    guildID,
+		UserID:     userID,
+		ExportedAt: now,
+		Sessions:   []userSessionExport{},
+		History:    []userPhaseExport{},
+	}
+
+	if prefs, ok, err := store.LoadUserPrefs(guildID, userID); err != nil {
+		return data, err
+	} else if ok {
+		data.Preferences = &prefs
+	}
+
+	releases, err := store.LoadPendingReleases(guildID)
+	if err != nil {
+		return data, err
+	}
+	for _, r := range releases {
+		if r.UserID == userID {
+			r := r
+			data.PendingRelease = &r
+		}
+	}
+
+	sessions, err := store.LoadSessions()
+	if err != nil {
+		return data, err
+	}
+	for _, s := range sessions {
+		if s.GuildID != guildID {
+			continue
+		}
+		for _, m := range s.Members {
+			if m.ID == userID {
+				data.Sessions = append(data.Sessions, userSessionExport{VoiceChannelID: s.VoiceChannelID, Phase: s.Phase, Member: m})
+			}
+		}
+	}
+
+	records, err := store.LoadPhases(guildID, time.Time{})
+	if err != nil {
+		return data, err
+	}
+	for _, r := range records {
+		for _, p := range participantsOf(r) {
+			if p.UserID != userID {
+				continue
+			}
+			data.History = append(data.History, userPhaseExport{
+				VoiceChannelID:  r.VoiceChannelID,
+				Phase:           r.Phase,
+				StartedAt:       r.StartedAt,
+				EndedAt:         r.EndedAt,
+				DurationSeconds: int64(r.Duration / time.Second),
+				FocusedSeconds:  int64(p.Focused / time.Second),
+				Completed:       p.Completed,
+				Left:            p.Left,
+				Skipped:         r.Skipped,
+				Stopped:         r.Stopped,
+			})
+		}
+	}
+	return data, nil
+}
+
+// userID のデータを JSON と CSV にして DM で送る
+func exportUserData(discord Discord, store storage.Store, guildID GuildID, userID UserID) error {
+	data, err := collectUserData(store, guildID, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to collect data of %s: %w", userID, err)
+	}
+
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var history bytes.Buffer
+	w := csv.NewWriter(&history)
+	w.Write(userPhaseCSVHeader)
+	for _, e := range data.History {
+		w.Write(e.csvRecord())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	return discord.SendDirectMessageComplex(userID, &discordgo.MessageSend{
+		Content: "Here is everything the pomodoro bot stores about you in this server.",
+		Files: []*discordgo.File{
+			{Name: "pomodoro-data.json", ContentType: "application/json", Reader: bytes.NewReader(b)},
+			{Name: "pomodoro-history.csv", ContentType: "text/csv", Reader: &history},
+		},
+	})
+}
+
+// userID を動いているポモドーロから外して、保存したデータを消す
+func (b *Bot) deleteUserData(guild GuildInfo, userID UserID) error {
+	for _, room := range guild.Rooms {
+		b.forgetUserWithLock(room.VoiceChannelID, userID)
+	}
+	return b.store.DeleteUserData(guild.GuildID, userID)
+}
+
+// Lock を内部で行う
+func (b *Bot) forgetUserWithLock(voiceChannelID ChannelID, userID UserID) {
+	pomodoroWithLock := b.lookupPomodoroWithLock(voiceChannelID)
+	if pomodoroWithLock == nil {
+		return
+	}
+
+	pomodoroWithLock.lock.Lock()
+	pomodoro := pomodoroWithLock.pomo
+	if pomodoro == nil {
+		pomodoroWithLock.lock.Unlock()
+		return
+	}
+	defer b.releaseOrUnlockPomodoro(pomodoro, voiceChannelID)
+	pomodoro.ForgetMember(userID)
+}
+
+func (b *Bot) mydataCommand(s *discordgo.Session, guild GuildInfo, userID UserID, options []*discordgo.ApplicationCommandInteractionDataOption) (string, []discordgo.MessageComponent) {
+	switch options[0].Name {
+	case "export":
+		if err := exportUserData(NewDiscord(s), b.store, guild.GuildID, userID); err != nil {
+			log.Printf("Failed to export data of %s: %v", userID, err)
+			return "Failed to send your data. Please make sure you accept direct messages from this server.", nil
+		}
+		return "Sent your data by direct message.", nil
+	case "delete":
+		return "This permanently deletes your pomodoro history and preferences in this server. Are you sure?", []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Delete my data",
+						Style:    discordgo.DangerButton,
+						CustomID: mydataDeleteButtonCustomID + ":" + userID,
+					},
+				},
+			},
+		}
+	}
+	return "", nil
+}
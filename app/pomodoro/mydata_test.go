@@ -0,0 +1,120 @@
+package pomodoro
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+func TestExportUserData(t *testing.T) {
+	store := storage.NewMemory()
+	discord := newFakeDiscord()
+
+	for _, r := range []storage.PhaseRecord{
+		{GuildID: testGuildID, VoiceChannelID: testVoiceChannelID, Phase: storage.PhaseTask, EndedAt: oct(1, 9), Duration: 25 * time.Minute, Participants: []storage.Participant{
+			{UserID: "alice", Focused: 25 * time.Minute, Completed: true},
+			{UserID: "bob", Focused: 10 * time.Minute, Left: true},
+		}},
+		{GuildID: testGuildID, VoiceChannelID: testVoiceChannelID, Phase: storage.PhaseTask, EndedAt: oct(1, 10), Duration: 25 * time.Minute, Participants: []storage.Participant{
+			{UserID: "bob", Focused: 25 * time.Minute, Completed: true},
+		}},
+		// 他のギルド
+		{GuildID: "other", Phase: storage.PhaseTask, EndedAt: oct(1, 9), Participants: []storage.Participant{{UserID: "alice", Completed: true}}},
+	} {
+		if err := store.AppendPhase(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveUserPrefs(storage.UserPrefs{GuildID: testGuildID, UserID: "alice", TimeZone: "UTC"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveSession(storage.Session{GuildID: testGuildID, VoiceChannelID: testVoiceChannelID, Phase: storage.PhaseTask, Members: []storage.Member{{ID: "alice", Username: "Alice"}, {ID: "bob", Username: "Bob"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exportUserData(discord, store, testGuildID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if dms := discord.DirectMessages("alice"); len(dms) != 1 {
+		t.Fatalf("got %d direct messages, want 1", len(dms))
+	}
+	files := discord.DirectFiles("alice")
+
+	var data userDataExport
+	if err := json.Unmarshal(files["pomodoro-data.json"], &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Preferences == nil || data.Preferences.TimeZone != "UTC" {
+		t.Errorf("preferences = %+v", data.Preferences)
+	}
+	if len(data.Sessions) != 1 || data.Sessions[0].Member.ID != "alice" {
+		t.Errorf("sessions = %+v", data.Sessions)
+	}
+	if len(data.History) != 1 || !data.History[0].Completed || data.History[0].FocusedSeconds != 25*60 {
+		t.Errorf("history = %+v", data.History)
+	}
+	// 他のメンバーの情報は含めない
+	if bytes.Contains(files["pomodoro-data.json"], []byte("bob")) {
+		t.Errorf("data of bob was exported: %s", files["pomodoro-data.json"])
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(files["pomodoro-history.csv"])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][5] != "1500" || rows[1][6] != "true" {
+		t.Errorf("csv = %v", rows)
+	}
+}
+
+func TestDeleteUserData(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+	discord := newFakeDiscord()
+	if err := store.AppendPhase(completedTask("alice", oct(1, 9))); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveUserPrefs(storage.UserPrefs{GuildID: testGuildID, UserID: "alice", StreakReminder: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", testVoiceChannelID))
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", "", testVoiceChannelID))
+	t.Cleanup(func() {
+		b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", testVoiceChannelID, ""))
+	})
+
+	if err := b.deleteUserData(testGuildInfo(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// VC にいても外して、mute を解除する
+	if _, ok := b.findRoomWithMember(testGuildInfo(), "alice"); ok {
+		t.Error("alice is still a member")
+	}
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); mute || deaf {
+		t.Errorf("alice: mute = %v, deaf = %v", mute, deaf)
+	}
+	if _, ok := b.findRoomWithMember(testGuildInfo(), "bob"); !ok {
+		t.Error("bob was removed")
+	}
+
+	if phases, _ := store.LoadPhases(testGuildID, time.Time{}); len(phases) != 1 || len(participantsOf(phases[0])) != 0 {
+		t.Errorf("history of alice was not deleted: %+v", phases)
+	}
+	if _, ok, _ := store.LoadUserPrefs(testGuildID, "alice"); ok {
+		t.Error("prefs of alice were not deleted")
+	}
+	sessions, _ := store.LoadSessions()
+	for _, s := range sessions {
+		for _, m := range s.Members {
+			if m.ID == "alice" {
+				t.Errorf("alice is still in the session: %+v", s)
+			}
+		}
+	}
+}
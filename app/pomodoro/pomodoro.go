@@ -1,13 +1,16 @@
 package pomodoro
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
-	"golang.org/x/text/language"
+	"github.com/pollenjp/pomodoro-bot/app/clock"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
 )
 
 type PomodoroStatus int
@@ -16,119 +19,463 @@ const (
 	PomodoroStatusStop PomodoroStatus = iota
 	PomodoroStatusTask
 	PomodoroStatusBreakTime
+	PomodoroStatusLongBreakTime
 )
 
+func (s PomodoroStatus) phaseKind() PhaseKind {
+	switch s {
+	case PomodoroStatusBreakTime:
+		return PhaseKindBreak
+	case PomodoroStatusLongBreakTime:
+		return PhaseKindLongBreak
+	default:
+		return PhaseKindTask
+	}
+}
+
 const (
 	PomodoroTaskDuration            = "25m"
 	PomodoroBreakDuration           = "5m"
 	PomodoroWarningEndBreakDuration = "10s"
+	PomodoroLongBreakDuration       = "15m"
+	PomodoroLongBreakInterval       = 4
+	PomodoroStreakGoal              = 1
 )
 
-// Start() でスタート
-// Stop() でストップ
-// Stop() 後に struct を破棄する
+// Pomodoro の状態はすべて run goroutine だけが触る
+// 公開メソッドは call で run goroutine に処理を渡して終わるのを待つ
+// 破棄するときは Close() で goroutine を終了させる
 type Pomodoro struct {
-	session       *discordgo.Session
-	guildID       ChannelID
-	textChannelID ChannelID
+	discord        Discord
+	guildID        GuildID
+	voiceChannelID ChannelID
+	textChannelID  ChannelID
+	locale         string
+	bundle         *i18n.Bundle
 	// Joining users
-	members                 map[UserID]discordgo.User
-	status                  PomodoroStatus `default:"PomodoroStatusStop"`
-	taskDuration            time.Duration
-	breakDuration           time.Duration
-	warningEndBreakDuration time.Duration
-	timer                   *time.Timer
-	taskEndTimerCh          chan struct{}
-	breakEndTimerCh         chan struct{}
-	stopCh                  chan struct{}
-	wg                      sync.WaitGroup
+	members map[UserID]discordgo.User
+	// 参加する前から付いていた server mute/deafen (bot はこれを外さない)
+	kept map[UserID]VoiceFlags
+	// bot が付けている server mute/deafen
+	applied map[UserID]VoiceFlags
+	// ルームとギルドの設定 (ユーザーの設定はその都度読む)
+	roomEnforcement  Enforcement
+	guildEnforcement Enforcement
+	status           PomodoroStatus `default:"PomodoroStatusStop"`
+	mode             PomodoroMode
+	schedule         Schedule
+	reminders        Reminders
+	// index of the current phase in schedule.Phases
+	phaseIndex int
+	// number of times schedule.Phases has been run through
+	round int
+	// number of task phases completed in this session
+	completedTasks      int
+	tasksSinceLongBreak int
+	completedBreaks     int
+	// 何回休憩を終えたら自動で停止するか (0 なら停止しない)
+	cycles int
+	// flowtime mode で集中した時間に対する休憩時間の割合
+	flowtimeBreakRatio float64
+	// 連続記録に必要な 1 日のポモドーロの数
+	streakGoal int
+	// ギルドのタイムゾーン
+	location *time.Location
+	// start time of the current phase (flowtime mode)
+	phaseStartedAt time.Time
+	// focus time before the last pause (flowtime mode)
+	elapsedBeforePause time.Duration
+	lastFocusDuration  time.Duration
+	// end time of the current phase
+	phaseEndAt time.Time
+	paused     bool
+	// time left in the current phase when paused
+	remaining time.Duration
+	// start time of the current phase (history)
+	phaseBeganAt time.Time
+	pausedAt     time.Time
+	// 現在のフェーズで一時停止していた時間
+	pausedFor time.Duration
+	// 現在のフェーズに参加した時点の phaseActive (最初からいれば 0)
+	joinedActive map[UserID]time.Duration
+	// 現在のフェーズの途中で抜けたメンバー
+	leftParticipants []storage.Participant
+
+	store storage.Store
+	// 最後に保存したセッション (保存していなければ nil)
+	savedSession *storage.Session
+
+	clock     clock.Clock
+	scheduler *phaseScheduler
+	requests  chan func()
+	// lifetime of the run goroutine
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	// cancelled when the running session stops
+	sessionCtx    context.Context
+	sessionCancel context.CancelFunc
 }
 
-func NewPomodoro(session *discordgo.Session, guildID ChannelID, textChannelID ChannelID) (*Pomodoro, error) {
+// room は GuildInfo.Room で取得したもの
+func (b *Bot) NewPomodoro(ctx context.Context, discord Discord, guild GuildInfo, room RoomInfo) (*Pomodoro, error) {
+	return b.newPomodoro(ctx, clock.Real, discord, guild, room)
+}
 
-	taskDuration, err := time.ParseDuration(PomodoroTaskDuration)
+func (b *Bot) newPomodoro(ctx context.Context, c clock.Clock, discord Discord, guild GuildInfo, room RoomInfo) (*Pomodoro, error) {
+
+	config, err := b.GetGuildConfig(guild.GuildID)
 	if err != nil {
 		return nil, err
 	}
 
-	breakDuration, err := time.ParseDuration(PomodoroBreakDuration)
+	// ルームのスケジュールがあればギルドの設定より優先する
+	if room.Schedule != "" {
+		config.ScheduleSpec = room.Schedule
+	}
+	schedule, err := config.BuildSchedule()
 	if err != nil {
 		return nil, err
 	}
 
-	warningEndBreakDuration, err := time.ParseDuration(PomodoroWarningEndBreakDuration)
-	if err != nil {
-		return nil, err
+	p := &Pomodoro{
+		discord:            discord,
+		guildID:            guild.GuildID,
+		voiceChannelID:     room.VoiceChannelID,
+		textChannelID:      room.ChannelIDForNotification,
+		locale:             guild.Locale,
+		bundle:             b.bundle,
+		members:            make(map[UserID]discordgo.User),
+		kept:               make(map[UserID]VoiceFlags),
+		applied:            make(map[UserID]VoiceFlags),
+		joinedActive:       make(map[UserID]time.Duration),
+		roomEnforcement:    room.Enforcement,
+		guildEnforcement:   config.Enforcement,
+		status:             PomodoroStatusStop,
+		mode:               config.Mode,
+		schedule:           schedule,
+		reminders:          config.Reminders.Copy(),
+		flowtimeBreakRatio: config.FlowtimeBreakRatio,
+		cycles:             config.Cycles,
+		streakGoal:         config.StreakGoal,
+		location:           guild.location(),
+		clock:              c,
+		scheduler:          newPhaseScheduler(c),
+		store:              b.store,
+		requests:           make(chan func()),
+		done:               make(chan struct{}),
 	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
 
-	return &Pomodoro{
-		session:                 session,
-		guildID:                 guildID,
-		textChannelID:           textChannelID,
-		members:                 make(map[UserID]discordgo.User),
-		status:                  PomodoroStatusStop,
-		taskDuration:            taskDuration,
-		breakDuration:           breakDuration,
-		warningEndBreakDuration: warningEndBreakDuration,
-	}, nil
+	go p.run()
+
+	return p, nil
 
 }
 
-func (p *Pomodoro) GetStatus() PomodoroStatus {
-	return p.status
-}
-
-func (p *Pomodoro) Start() {
-	log.Print("Pomodoro start!")
-
-	p.taskEndTimerCh = make(chan struct{}, 1)
-	p.breakEndTimerCh = make(chan struct{}, 1)
-	p.stopCh = make(chan struct{}, 1)
-
-	p.wg.Add(1)
-	go func() {
-		defer p.wg.Done()
-		// infinite loop
-		for {
-			log.Print("Pomodoro loop!")
-			select {
-			case <-p.taskEndTimerCh: // end task
-				p.Break()
-			case <-p.breakEndTimerCh:
-				p.Task()
-			case <-p.stopCh:
-				log.Print("Get stop channel...")
-				p.timer.Stop()
-				close(p.taskEndTimerCh)
-				close(p.breakEndTimerCh)
-				close(p.stopCh)
-				log.Print("Stopped pomodoro timer!")
-				return
+func (p *Pomodoro) localizer() *i18n.Localizer {
+	return GuildInfo{Locale: p.locale}.localizer(p.bundle)
+}
+
+func (p *Pomodoro) run() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.ctx.Done():
+			p.scheduler.cancelAll()
+			if p.sessionCancel != nil {
+				p.sessionCancel()
+			}
+			log.Print("Pomodoro goroutine finished!")
+			return
+		case f := <-p.requests:
+			f()
+		case ev := <-p.scheduler.events:
+			// 止めたタイマーのイベントは捨てる
+			if p.scheduler.isCurrent(ev) {
+				p.handleTimerEvent(ev)
+				p.persistSession()
 			}
+			close(ev.handled)
 		}
-	}()
+	}
+}
 
-	p.Task()
+// f を run goroutine で実行して、終わるまで待つ
+// goroutine が既に終了していれば何もしない
+func (p *Pomodoro) call(f func()) {
+	done := make(chan struct{})
+	select {
+	case p.requests <- func() {
+		defer close(done)
+		f()
+		p.persistSession()
+	}:
+		<-done
+	case <-p.done:
+		log.Print("Pomodoro is already closed!")
+	}
+}
 
+// 動いていれば停止して、run goroutine を終了する
+// Close() の後に Pomodoro を使ってはいけない
+func (p *Pomodoro) Close() {
+	p.call(func() {
+		if p.status != PomodoroStatusStop {
+			p.stop()
+		}
+	})
+	p.cancel()
+	<-p.done
 }
 
-func (p *Pomodoro) Task() {
-	p.status = PomodoroStatusTask
+func (p *Pomodoro) handleTimerEvent(ev timerEvent) {
+	switch ev.kind {
+	case timerEventReminder:
+		p.notifyPhaseEndSoon(ev.phaseKind, ev.offset)
+	case timerEventPhaseEnd:
+		p.recordPhase(phaseCompleted)
+		p.endPhase()
+	}
+}
 
-	// timer for Task
+func (p *Pomodoro) GetStatus() PomodoroStatus {
+	var status PomodoroStatus
+	p.call(func() {
+		status = p.status
+	})
+	return status
+}
+
+func (p *Pomodoro) IsPaused() bool {
+	var paused bool
+	p.call(func() {
+		paused = p.paused
+	})
+	return paused
+}
+
+func (p *Pomodoro) GetSchedule() Schedule {
+	var schedule Schedule
+	p.call(func() {
+		schedule = p.schedule
+	})
+	return schedule
+}
+
+func (p *Pomodoro) MemberCount() int {
+	var n int
+	p.call(func() {
+		n = len(p.members)
+	})
+	return n
+}
+
+func (p *Pomodoro) HasMember(userID UserID) bool {
+	var ok bool
+	p.call(func() {
+		_, ok = p.members[userID]
+	})
+	return ok
+}
+
+// 停止中のみ変更できる
+func (p *Pomodoro) SetSchedule(schedule Schedule) error {
+	var err error
+	p.call(func() {
+		if p.status != PomodoroStatusStop {
+			err = fmt.Errorf("cannot change the schedule of a running pomodoro")
+			return
+		}
+		p.schedule = schedule
+	})
+	return err
+}
+
+// 停止中のみ変更できる
+func (p *Pomodoro) SetCycles(cycles int) error {
+	var err error
+	p.call(func() {
+		if p.status != PomodoroStatusStop {
+			err = fmt.Errorf("cannot change the cycles of a running pomodoro")
+			return
+		}
+		if cycles < 0 {
+			err = fmt.Errorf("cycles must not be negative: %d", cycles)
+			return
+		}
+		p.cycles = cycles
+	})
+	return err
+}
+
+func (p *Pomodoro) currentPhase() Phase {
+	return p.schedule.Phases[p.phaseIndex]
+}
+
+func (p *Pomodoro) start() {
+	if p.mode == PomodoroModeFlowtime {
+		log.Printf("Pomodoro start! (mode: %s)", p.mode)
+	} else {
+		log.Printf("Pomodoro start! (schedule: %s)", p.schedule)
+	}
+
+	p.phaseIndex = 0
+	p.round = 0
+	p.completedTasks = 0
+	p.tasksSinceLongBreak = 0
+	p.completedBreaks = 0
+	p.paused = false
+
+	// セッションを止めるとこのセッションのタイマーはすべてキャンセルされる
+	p.sessionCtx, p.sessionCancel = context.WithCancel(p.ctx)
+
+	if p.mode == PomodoroModeFlowtime {
+		p.flowTask()
+	} else {
+		p.startPhase()
+	}
+
+}
+
+// 現在のフェーズを終えて次のフェーズへ進む
+// タイマーが発火したとき、skip したとき、flowtime で休憩に入るときに呼ばれる
+func (p *Pomodoro) endPhase() {
+	switch p.status {
+	case PomodoroStatusTask:
+		p.completedTasks++
+		p.tasksSinceLongBreak++
+		if p.mode == PomodoroModeFlowtime {
+			p.flowBreak()
+			return
+		}
+	case PomodoroStatusBreakTime, PomodoroStatusLongBreakTime:
+		p.completedBreaks++
+		if p.cycles > 0 && p.completedBreaks >= p.cycles {
+			log.Printf("Finished %d cycles!", p.cycles)
+			p.finish()
+			return
+		}
+		if p.mode == PomodoroModeFlowtime {
+			p.flowTask()
+			return
+		}
+	default:
+		return
+	}
+
+	if !p.nextPhase() {
+		p.finish()
+	}
+}
+
+// 現在のフェーズを開始する
+func (p *Pomodoro) startPhase() {
+	phase := p.currentPhase()
+	switch phase.Kind {
+	case PhaseKindTask:
+		p.task(phase.Duration)
+	case PhaseKindBreak:
+		p.startBreak(PomodoroStatusBreakTime, phase.Duration)
+	case PhaseKindLongBreak:
+		p.startBreak(PomodoroStatusLongBreakTime, phase.Duration)
+	}
+}
+
+// スケジュールの次のフェーズへ進む
+// スケジュールが終わった場合は false を返す
+func (p *Pomodoro) nextPhase() bool {
+	if p.currentPhase().Kind == PhaseKindLongBreak {
+		p.tasksSinceLongBreak = 0
+	}
+
+	p.phaseIndex++
+	if p.phaseIndex >= len(p.schedule.Phases) {
+		p.phaseIndex = 0
+		p.round++
+		if p.schedule.Repeat > 0 && p.round >= p.schedule.Repeat {
+			return false
+		}
+	}
+
+	p.startPhase()
+	return true
+}
+
+// スケジュールを最後まで終えたとき、または cycles 回の休憩を終えたときに呼ばれる
+// メンバーは残したまま停止状態に戻す
+func (p *Pomodoro) finish() {
+	p.stopSession()
 
-	if p.timer != nil {
-		p.timer.Stop()
+	p.releaseAllMembers()
+
+	localizer := p.localizer()
+	var msg string
+	messageID := "The schedule has finished!"
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
 	}
 
-	p.timer = time.AfterFunc(
-		p.taskDuration,
-		func() {
-			p.taskEndTimerCh <- struct{}{}
+	msg += "\n"
+
+	// closing summary
+	messageID = "Completed Count tasks."
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: messageID,
+		TemplateData: map[string]interface{}{
+			"Count": p.completedTasks,
 		},
-	)
+		PluralCount: p.completedTasks,
+	}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
+	log.Print(msg)
+	p.messageWithAllMembersMention(msg)
+}
+
+// セッションのタイマーをすべて止めて停止状態にする
+func (p *Pomodoro) stopSession() {
+	p.scheduler.cancelAll()
+	if p.sessionCancel != nil {
+		p.sessionCancel()
+	}
+	p.status = PomodoroStatusStop
+	p.paused = false
+}
+
+// 新しいフェーズの開始時刻を記録する
+// 今いるメンバーは最初から参加したことになる
+func (p *Pomodoro) beginPhase() {
+	p.phaseBeganAt = p.clock.Now()
+	p.pausedFor = 0
+	for userID := range p.members {
+		p.joinedActive[userID] = 0
+	}
+	p.leftParticipants = nil
+}
 
-	localizer := i18n.NewLocalizer(I18nBundle, language.Japanese.String())
+// 現在のフェーズの経過時間 (一時停止していた時間は含まない)
+func (p *Pomodoro) phaseActive() time.Duration {
+	now := p.clock.Now()
+	d := now.Sub(p.phaseBeganAt) - p.pausedFor
+	if p.paused {
+		d -= now.Sub(p.pausedAt)
+	}
+	return d
+}
+
+func (p *Pomodoro) task(taskDuration time.Duration) {
+	p.status = PomodoroStatusTask
+	p.beginPhase()
+
+	// timer for Task
+	p.armPhaseTimer(taskDuration)
+
+	localizer := p.localizer()
 	msg := ""
 
 	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: "Start your task!"}); err == nil {
@@ -139,7 +486,7 @@ func (p *Pomodoro) Task() {
 
 	msg += "\n"
 
-	d := int(p.taskDuration.Minutes())
+	d := int(taskDuration.Minutes())
 	if m, err := localizer.Localize(&i18n.LocalizeConfig{
 		MessageID: "Task will end in Min minutes.",
 		TemplateData: map[string]interface{}{
@@ -154,7 +501,7 @@ func (p *Pomodoro) Task() {
 
 	msg += "\n"
 
-	t := time.Now().Add(p.taskDuration)
+	t := p.phaseEndAt.In(p.location)
 	if m, err := localizer.Localize(&i18n.LocalizeConfig{
 		MessageID: "The task will end at DateTime.",
 		TemplateData: map[string]interface{}{
@@ -167,71 +514,97 @@ func (p *Pomodoro) Task() {
 	}
 
 	log.Print(msg)
-	p.messageWithAllMembersMention(msg)
-	p.muteAndDeafenAllMembers()
+	p.messageWithAllMembersMentionAndStreaks(msg, p.memberStreaks(), nil)
+	p.enforceAllMembers()
 }
 
 func (p *Pomodoro) messageWithAllMembersMention(msg string) {
+	p.messageWithAllMembersMentionAndStreaks(msg, nil, nil)
+}
+
+// streaks にあるメンバーはメンションの横に連続記録を付ける
+func (p *Pomodoro) messageWithAllMembersMentionAndStreaks(msg string, streaks map[UserID]int, components []discordgo.MessageComponent) {
+	localizer := p.localizer()
 	mention := ""
 	for _, user := range p.members {
 		mention += "<@" + user.ID + "> "
+		if days, ok := streaks[user.ID]; ok {
+			messageID := "Days-day streak"
+			if m, err := localizer.Localize(&i18n.LocalizeConfig{
+				MessageID: messageID,
+				TemplateData: map[string]interface{}{
+					"Days": days,
+				},
+			}); err == nil {
+				mention += m + " "
+			} else {
+				mention += messageID + " "
+			}
+		}
 	}
 	msg = mention + "\n" + msg
-	if _, err := p.session.ChannelMessageSend(p.textChannelID, msg); err != nil {
+	if err := p.discord.SendMessageComplex(p.textChannelID, &discordgo.MessageSend{
+		Content:    msg,
+		Components: components,
+	}); err != nil {
 		log.Printf("Error sending message: %v", err)
 	}
 }
 
-func (p *Pomodoro) Break() {
-	p.status = PomodoroStatusBreakTime
+// 現在のフェーズが d 後に終わるようにタイマーをセットし直す
+// 終了前のリマインダーもセットする
+// それまでのフェーズのタイマーはすべてキャンセルされる
+func (p *Pomodoro) armPhaseTimer(d time.Duration) {
+	p.scheduler.reset(p.sessionCtx)
 
-	// timer for break
+	p.phaseEndAt = p.clock.Now().Add(d)
 
-	if p.timer != nil {
-		p.timer.Stop()
+	if p.isFlowtimeTask() {
+		// flowtime mode のタスクには終わりの時間がない
+		return
 	}
 
-	localizer := i18n.NewLocalizer(I18nBundle, language.Japanese.String())
+	p.scheduler.after(d, timerEvent{kind: timerEventPhaseEnd})
+	p.armReminders(p.status.phaseKind(), d)
+}
 
-	p.timer = time.AfterFunc(
-		p.breakDuration-p.warningEndBreakDuration,
-		func() {
-			time.AfterFunc(
-				p.warningEndBreakDuration,
-				func() {
-					p.breakEndTimerCh <- struct{}{}
-				},
-			)
+// 通常の休憩と長い休憩で共通の処理
+func (p *Pomodoro) startBreak(status PomodoroStatus, breakDuration time.Duration) {
+	p.status = status
+	p.beginPhase()
+
+	messageIDs := struct {
+		started string
+		endIn   string
+		endAt   string
+	}{
+		started: "The break has started!",
+		endIn:   "The break will end in Min minutes.",
+		endAt:   "The break will end at DateTime.",
+	}
+	if status == PomodoroStatusLongBreakTime {
+		messageIDs.started = "The long break has started!"
+		messageIDs.endIn = "The long break will end in Min minutes."
+		messageIDs.endAt = "The long break will end at DateTime."
+	}
 
-			// send message to all members
-			var msg string
-			messageID := "The break time will end soon!"
-			if m, err := localizer.Localize(&i18n.LocalizeConfig{
-				MessageID: messageID,
-				TemplateData: map[string]interface{}{
-					"Duration": PomodoroWarningEndBreakDuration,
-				},
-			}); err == nil {
-				msg += m
-			} else {
-				msg += messageID
-			}
-			p.messageWithAllMembersMention(msg)
-		},
-	)
+	// timer for break
+	p.armPhaseTimer(breakDuration)
+
+	localizer := p.localizer()
 
 	msg := ""
-	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: "The break has started!"}); err == nil {
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: messageIDs.started}); err == nil {
 		msg += m
 	} else {
-		msg += "The break has started!"
+		msg += messageIDs.started
 	}
 
 	msg += "\n"
 
-	d := int(p.breakDuration.Minutes())
+	d := int(breakDuration.Minutes())
 	if m, err := localizer.Localize(&i18n.LocalizeConfig{
-		MessageID: "The break will end in Min minutes.",
+		MessageID: messageIDs.endIn,
 		TemplateData: map[string]interface{}{
 			"Min": d,
 		},
@@ -239,125 +612,397 @@ func (p *Pomodoro) Break() {
 	}); err == nil {
 		msg += m
 	} else {
-		msg += "The break will end in Min minutes"
+		msg += messageIDs.endIn
 	}
 
 	msg += "\n"
 
-	t := time.Now().Add(p.breakDuration)
+	t := p.phaseEndAt.In(p.location)
+
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: messageIDs.endAt,
+		TemplateData: map[string]interface{}{
+			"DateTime": t.Format("2006/01/02") + " " + t.Format("15:04"),
+		},
+	}); err == nil {
+		msg += m
+	} else {
+		msg += messageIDs.endAt
+	}
+
+	// 長い休憩までの進捗 (長い休憩中は Interval/Interval になる)
+	// スケジュールに長い休憩がなければ表示しない
+	if p.mode == PomodoroModeFlowtime {
+		msg += "\n"
+		messageID := "You focused for Duration."
+		if m, err := localizer.Localize(&i18n.LocalizeConfig{
+			MessageID: messageID,
+			TemplateData: map[string]interface{}{
+				"Duration": p.lastFocusDuration.Round(time.Second).String(),
+			},
+		}); err == nil {
+			msg += m
+		} else {
+			msg += messageID
+		}
+	} else if left := p.schedule.tasksUntilLongBreak(p.phaseIndex); left >= 0 {
+		msg += "\n"
+		messageID := "Count/Interval until long break"
+		if m, err := localizer.Localize(&i18n.LocalizeConfig{
+			MessageID: messageID,
+			TemplateData: map[string]interface{}{
+				"Count":    p.tasksSinceLongBreak,
+				"Interval": p.tasksSinceLongBreak + left,
+			},
+		}); err == nil {
+			msg += m
+		} else {
+			msg += messageID
+		}
+	}
+
+	log.Print(msg)
+
+	p.messageWithAllMembersMention(msg)
+	p.releaseAllMembers()
+}
+
+// 現在のフェーズの残り時間を記録してタイマーを止める
+// 一時停止中は全員の mute/deafen を解除する
+func (p *Pomodoro) Pause() error {
+	var err error
+	p.call(func() {
+		err = p.pause()
+	})
+	return err
+}
+
+func (p *Pomodoro) pause() error {
+	if p.status == PomodoroStatusStop {
+		return fmt.Errorf("pomodoro is not running")
+	}
+	if p.paused {
+		return fmt.Errorf("pomodoro is already paused")
+	}
+
+	p.scheduler.cancelAll()
 
+	messageID := "Paused. Duration left."
+	duration := time.Duration(0)
+	if p.isFlowtimeTask() {
+		// flowtime mode では残り時間ではなく集中した時間を記録する
+		p.elapsedBeforePause = p.focusElapsed()
+		messageID = "Paused. Duration focused."
+		duration = p.elapsedBeforePause
+	} else {
+		p.remaining = p.phaseEndAt.Sub(p.clock.Now())
+		if p.remaining < 0 {
+			p.remaining = 0
+		}
+		duration = p.remaining
+	}
+	p.paused = true
+	p.pausedAt = p.clock.Now()
+	log.Printf("Pomodoro paused! (%s)", duration)
+
+	p.releaseAllMembers()
+
+	localizer := p.localizer()
+	var msg string
 	if m, err := localizer.Localize(&i18n.LocalizeConfig{
-		MessageID: "The break will end at DateTime.",
+		MessageID: messageID,
+		TemplateData: map[string]interface{}{
+			"Duration": duration.Round(time.Second).String(),
+		},
+	}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
+	p.messageWithAllMembersMention(msg)
+	return nil
+}
+
+// 一時停止したときの残り時間でタイマーをセットし直す
+func (p *Pomodoro) Resume() error {
+	var err error
+	p.call(func() {
+		err = p.resume()
+	})
+	return err
+}
+
+func (p *Pomodoro) resume() error {
+	if p.status == PomodoroStatusStop {
+		return fmt.Errorf("pomodoro is not running")
+	}
+	if !p.paused {
+		return fmt.Errorf("pomodoro is not paused")
+	}
+
+	p.paused = false
+	p.pausedFor += p.clock.Now().Sub(p.pausedAt)
+	messageID := "Resumed. The phase will end at DateTime."
+	if p.isFlowtimeTask() {
+		p.phaseStartedAt = p.clock.Now()
+		messageID = "Resumed. Take a break whenever you like."
+	} else {
+		p.armPhaseTimer(p.remaining)
+	}
+	log.Printf("Pomodoro resumed! (remaining: %s)", p.remaining)
+
+	if p.status == PomodoroStatusTask {
+		p.enforceAllMembers()
+	}
+
+	localizer := p.localizer()
+	var msg string
+	t := p.phaseEndAt.In(p.location)
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: messageID,
 		TemplateData: map[string]interface{}{
 			"DateTime": t.Format("2006/01/02") + " " + t.Format("15:04"),
 		},
 	}); err == nil {
 		msg += m
 	} else {
-		msg += "The break will end at DateTime."
+		msg += messageID
+	}
+	p.messageWithAllMembersMention(msg)
+	return nil
+}
+
+// タイマーの終了を待たずに次のフェーズへ移る
+// タイマーが発火したときと同じ endPhase を通る
+func (p *Pomodoro) Skip() error {
+	var err error
+	p.call(func() {
+		err = p.skip()
+	})
+	return err
+}
+
+func (p *Pomodoro) skip() error {
+	if p.status == PomodoroStatusStop {
+		return fmt.Errorf("pomodoro is not running")
+	}
+
+	p.recordPhase(phaseSkipped)
+
+	p.scheduler.cancelAll()
+	if p.paused && p.isFlowtimeTask() {
+		// 一時停止中の時間を集中した時間に含めない
+		p.phaseStartedAt = p.clock.Now()
 	}
+	p.paused = false
 
+	localizer := p.localizer()
+	var msg string
+	messageID := "Skipped the current phase!"
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
 	log.Print(msg)
+	p.messageWithAllMembersMention(msg)
 
+	p.endPhase()
+	return nil
+}
+
+// 現在のフェーズを d だけ延長する
+func (p *Pomodoro) Extend(d time.Duration) error {
+	var err error
+	p.call(func() {
+		err = p.extend(d)
+	})
+	return err
+}
+
+func (p *Pomodoro) extend(d time.Duration) error {
+	if p.status == PomodoroStatusStop {
+		return fmt.Errorf("pomodoro is not running")
+	}
+	if d <= 0 {
+		return fmt.Errorf("duration must be positive: %s", d)
+	}
+	if p.isFlowtimeTask() {
+		return fmt.Errorf("a flowtime task has no end time to extend")
+	}
+
+	var endAt time.Time
+	if p.paused {
+		// 一時停止中は残り時間だけ延ばす
+		p.remaining += d
+		endAt = p.clock.Now().Add(p.remaining)
+	} else {
+		p.armPhaseTimer(p.phaseEndAt.Sub(p.clock.Now()) + d)
+		endAt = p.phaseEndAt
+	}
+	endAt = endAt.In(p.location)
+	log.Printf("Pomodoro extended by %s", d)
+
+	localizer := p.localizer()
+	var msg string
+	messageID := "Extended by Min minutes. The phase will end at DateTime."
+	minutes := int(d.Minutes())
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: messageID,
+		TemplateData: map[string]interface{}{
+			"Min":      minutes,
+			"DateTime": endAt.Format("2006/01/02") + " " + endAt.Format("15:04"),
+		},
+		PluralCount: minutes,
+	}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
+	log.Print(msg)
 	p.messageWithAllMembersMention(msg)
-	p.muteAndDeafenAllMembers()
-	p.unMuteAndUnDeafenAllMembers()
+	return nil
 }
 
 func (p *Pomodoro) Stop() {
-	log.Print("Trying to stop Pomodoro...")
-	p.stopCh <- struct{}{}
-	p.status = PomodoroStatusStop
+	p.call(p.stop)
+}
 
-	// wait for goroutine to finish
-	p.wg.Wait()
+func (p *Pomodoro) stop() {
+	log.Print("Trying to stop Pomodoro...")
+	p.recordPhase(phaseStopped)
+	p.stopSession()
+	log.Print("Stopped pomodoro timer!")
 
-	p.unMuteAndUnDeafenAllMembers()
+	p.releaseAllMembers()
 
 	msg := "Pomodoro is over!\n"
-	msg += "If you want to get out from the pomodoro VC while tasking and deaf, move to another VC from pomodoro's.\n"
-	msg += "Bot can un-deafen a user only in some VC."
+	msg += "If you left the VC while deafened, you will be un-deafened the next time you join a voice channel."
 	log.Print(msg)
-	if _, err := p.session.ChannelMessageSend(p.textChannelID, msg); err != nil {
+	if err := p.discord.SendMessage(p.textChannelID, msg); err != nil {
 		log.Printf("Error sending message: %v", err)
 	}
 }
 
-func (p *Pomodoro) AddMember(user discordgo.User) {
+func (p *Pomodoro) addMember(user discordgo.User) {
 	p.members[user.ID] = user
+	if p.status != PomodoroStatusStop {
+		p.joinedActive[user.ID] = p.phaseActive()
+	}
 
 	msg := "Welcome <@" + user.ID + "> !"
-	switch p.status {
-	case PomodoroStatusTask:
+	switch {
+	case p.paused:
+		msg += "Paused now!"
+	case p.status == PomodoroStatusTask:
 		msg += "Tasking now!"
-	case PomodoroStatusBreakTime:
+	case p.status == PomodoroStatusBreakTime:
 		msg += "Breaking now!"
+	case p.status == PomodoroStatusLongBreakTime:
+		msg += "Long breaking now!"
 	}
-	if _, err := p.session.ChannelMessageSend(p.textChannelID, msg); err != nil {
+	if err := p.discord.SendMessage(p.textChannelID, msg); err != nil {
 		log.Printf("Error sending message: %v", err)
 	}
 
 }
 
-func (p *Pomodoro) AddMemberWithServerMuteDeaf(user discordgo.User) {
-	p.session.GuildMemberMute(p.guildID, user.ID, true)
-	p.session.GuildMemberDeafen(p.guildID, user.ID, true)
-	p.AddMember(user)
+func (p *Pomodoro) addMemberWithServerMuteDeaf(user discordgo.User) {
+	p.enforce(user.ID, true)
+	p.addMember(user)
 }
 
-func (p *Pomodoro) RemoveMember(userID UserID) {
-	p.session.GuildMemberMute(p.guildID, userID, false)
-	p.session.GuildMemberDeafen(p.guildID, userID, false)
-	delete(p.members, userID)
-	log.Printf("Removed member: %s", userID)
+// bot が付けた mute/deafen を外してメンバーから除く
+// 外した後に残っている server mute/deafen を返す
+// メンバーでなければ何もせず false を返す
+func (p *Pomodoro) RemoveMember(userID UserID) (VoiceFlags, bool) {
+	var kept VoiceFlags
+	var ok bool
+	p.call(func() {
+		kept, ok = p.removeMember(userID)
+	})
+	return kept, ok
 }
 
-func (p *Pomodoro) muteAndDeafenAllMembers() {
-	for userID := range p.members {
-		p.session.GuildMemberMute(p.guildID, userID, true)
-		p.session.GuildMemberDeafen(p.guildID, userID, true)
+func (p *Pomodoro) removeMember(userID UserID) (VoiceFlags, bool) {
+	if _, ok := p.members[userID]; !ok {
+		return VoiceFlags{}, false
+	}
+	kept := p.kept[userID]
+	p.enforce(userID, false)
+	if p.status != PomodoroStatusStop {
+		p.leftParticipants = append(p.leftParticipants, storage.Participant{
+			UserID:  userID,
+			Focused: p.phaseActive() - p.joinedActive[userID],
+			Left:    true,
+		})
 	}
+	delete(p.members, userID)
+	delete(p.kept, userID)
+	delete(p.applied, userID)
+	delete(p.joinedActive, userID)
+	log.Printf("Removed member: %s", userID)
+	return kept, true
 }
 
-func (p *Pomodoro) unMuteAndUnDeafenAllMembers() {
-	for userID := range p.members {
-		p.session.GuildMemberMute(p.guildID, userID, false)
-		p.session.GuildMemberDeafen(p.guildID, userID, false)
-	}
+// ForgetMember はメンバーから外し、現在のフェーズの記録にも残さない
+// 既に抜けていても途中で抜けた記録を消す
+func (p *Pomodoro) ForgetMember(userID UserID) {
+	p.call(func() {
+		p.removeMember(userID)
+		var left []storage.Participant
+		for _, participant := range p.leftParticipants {
+			if participant.UserID != userID {
+				left = append(left, participant)
+			}
+		}
+		p.leftParticipants = left
+	})
 }
 
 // Add a new user to a pomodoro's member list
-func (p *Pomodoro) AddUser(user discordgo.User) {
-	switch p.GetStatus() {
+// voice は参加したときの server mute/deafen で、bot はこれを外さない
+func (p *Pomodoro) AddUser(user discordgo.User, voice VoiceFlags) {
+	p.call(func() {
+		p.addUser(user, voice)
+	})
+}
+
+func (p *Pomodoro) addUser(user discordgo.User, voice VoiceFlags) {
+	if _, ok := p.members[user.ID]; !ok {
+		// 既にメンバーなら今の状態は bot が付けたものかもしれない
+		p.kept[user.ID] = voice
+	}
+	switch p.status {
 	case PomodoroStatusStop:
 		// Start Pomodoro
-		p.AddMemberWithServerMuteDeaf(user)
-		p.Start()
+		p.addMemberWithServerMuteDeaf(user)
+		p.start()
 	case PomodoroStatusTask:
+		if p.paused {
+			// 一時停止中であれば mute しない
+			p.addMember(user)
+			break
+		}
 		// task中であれば入ってきた人をmute
-		p.AddMemberWithServerMuteDeaf(user)
-	case PomodoroStatusBreakTime:
+		p.addMemberWithServerMuteDeaf(user)
+	case PomodoroStatusBreakTime, PomodoroStatusLongBreakTime:
 		// 休憩中であれば入ってきた人を追加するが mute しない
-		p.AddMember(user)
+		p.addMember(user)
 	}
 }
 
-var (
-	pomodoroMapLock sync.Mutex
-	pomodoroMap     map[GuildID]*PomodoroWithLock = make(map[GuildID]*PomodoroWithLock)
-)
-
 type PomodoroWithLock struct {
 	pomo *Pomodoro
 	lock sync.Mutex
 }
 
-func (pp *PomodoroWithLock) getPomodoro(session *discordgo.Session, guildID ChannelID, textChannelID ChannelID) (*Pomodoro, error) {
+func (pp *PomodoroWithLock) getPomodoro(b *Bot, discord Discord, guild GuildInfo, room RoomInfo) (*Pomodoro, error) {
 	pp.lock.Lock()
 	log.Print("Pomodoro was locked!")
 	if pp.pomo == nil {
 		var err error
-		if pp.pomo, err = NewPomodoro(session, guildID, textChannelID); err != nil {
+		if pp.pomo, err = b.NewPomodoro(context.Background(), discord, guild, room); err != nil {
 			pp.lock.Unlock()
 			return nil, err
 		}
@@ -365,109 +1010,150 @@ func (pp *PomodoroWithLock) getPomodoro(session *discordgo.Session, guildID Chan
 	return pp.pomo, nil
 }
 
-func getPomodoroWithLock(session *discordgo.Session, guildID GuildID, textChannelID ChannelID) (*Pomodoro, error) {
+func (b *Bot) getPomodoroWithLock(discord Discord, guild GuildInfo, room RoomInfo) (*Pomodoro, error) {
 	// if empty, create a new Pomodoro
-	pomodoroMapLock.Lock()
-	if pomodoroMap[guildID] == nil {
-		pomodoroMap[guildID] = &PomodoroWithLock{}
+	b.pomodoroMapLock.Lock()
+	if b.shuttingDown {
+		b.pomodoroMapLock.Unlock()
+		return nil, fmt.Errorf("bot is shutting down")
+	}
+	pomodoroWithLock := b.pomodoroMap[room.VoiceChannelID]
+	if pomodoroWithLock == nil {
+		pomodoroWithLock = &PomodoroWithLock{}
+		b.pomodoroMap[room.VoiceChannelID] = pomodoroWithLock
 	}
-	pomodoroMapLock.Unlock()
-	return pomodoroMap[guildID].getPomodoro(session, guildID, textChannelID)
+	b.pomodoroMapLock.Unlock()
+	return pomodoroWithLock.getPomodoro(b, discord, guild, room)
 }
 
-func unlockPomodoro(guildID GuildID) {
-	pomodoroMap[guildID].lock.Unlock()
+func (b *Bot) lookupPomodoroWithLock(voiceChannelID ChannelID) *PomodoroWithLock {
+	b.pomodoroMapLock.Lock()
+	defer b.pomodoroMapLock.Unlock()
+	return b.pomodoroMap[voiceChannelID]
+}
+
+func (b *Bot) unlockPomodoro(voiceChannelID ChannelID) {
+	b.lookupPomodoroWithLock(voiceChannelID).lock.Unlock()
 	log.Print("Pomodoro was unlocked!")
 }
 
-func releasePomodoroWithUnlock(guildID GuildID) {
-	pomodoroWithLock := pomodoroMap[guildID]
+// Lock を取った状態で呼ぶ
+func (b *Bot) releasePomodoroWithUnlock(voiceChannelID ChannelID) {
+	pomodoroWithLock := b.lookupPomodoroWithLock(voiceChannelID)
 	if pomodoroWithLock == nil {
-		log.Printf("Pomodoro in Guild ID (%s) is not found!", guildID)
+		log.Printf("Pomodoro in VC (%s) is not found!", voiceChannelID)
 		return
 	}
-	lock := &pomodoroWithLock.lock
-	if lock.TryLock() {
-		log.Print("Pomodoro was unlocked!?")
-	}
-	defer unlockPomodoro(guildID)
+	defer b.unlockPomodoro(voiceChannelID)
 
 	if pomo := pomodoroWithLock.pomo; pomo != nil {
-		// Stop timer
-		if pomo.status != PomodoroStatusStop {
-			pomo.Stop()
-		}
+		// Stop timer and goroutine
+		pomo.Close()
 		// release pomodoro
-		pomo = nil
-		log.Printf("Pomodoro for %v was released!", guildID)
+		pomodoroWithLock.pomo = nil
+		log.Printf("Pomodoro for %v was released!", voiceChannelID)
 	}
 }
 
-func releaseOrUnlockPomodoro(pomodoro *Pomodoro, guildID GuildID) {
+func (b *Bot) releaseOrUnlockPomodoro(pomodoro *Pomodoro, voiceChannelID ChannelID) {
 	if pomodoro == nil {
 		log.Printf("Try to release or unlock pomodoro, but pomodoro is nil!")
 		return
 	}
-	if len(pomodoro.members) == 0 {
-		defer releasePomodoroWithUnlock(guildID)
+	if pomodoro.MemberCount() == 0 {
+		defer b.releasePomodoroWithUnlock(voiceChannelID)
 	} else {
-		defer unlockPomodoro(guildID)
+		defer b.unlockPomodoro(voiceChannelID)
 	}
 }
 
 // 冪等性を持つ Remove User
 // Lock を内部で行う
-func SafeRemoveUserWithLock(guildID GuildID, userID UserID) {
-	pomodoroMapLock.Lock()
-	pomodoroWithLock, ok := pomodoroMap[guildID]
-	if !ok { // ポモドーロが開始していなければ何もしない
-		pomodoroMapLock.Unlock()
+func (b *Bot) SafeRemoveUserWithLock(voiceChannelID ChannelID, userID UserID) {
+	pomodoroWithLock := b.lookupPomodoroWithLock(voiceChannelID)
+	if pomodoroWithLock == nil { // ポモドーロが開始していなければ何もしない
 		return
 	}
-	pomodoroMapLock.Unlock()
 
 	pomodoroWithLock.lock.Lock()
 	pomodoro := pomodoroWithLock.pomo
-	defer releaseOrUnlockPomodoro(pomodoro, guildID)
+	defer b.releaseOrUnlockPomodoro(pomodoro, voiceChannelID)
 	if pomodoro == nil { // pomodoro が生成されていなければ何もしない
+		pomodoroWithLock.lock.Unlock()
 		return
 	}
 
 	pomodoro.RemoveMember(userID)
 }
 
-func onVoiceStateUpdate(session *discordgo.Session, updated *discordgo.VoiceStateUpdate) {
-	// log.Printf("%#v", session)
-	// log.Printf("onVoiceStateUpdate: %#v", updated)
-	// log.Printf("%s", updated.ChannelID)
+// userID が参加しているルームを探す
+func (b *Bot) findRoomWithMember(guild GuildInfo, userID UserID) (RoomInfo, bool) {
+	for _, room := range guild.Rooms {
+		pomodoroWithLock := b.lookupPomodoroWithLock(room.VoiceChannelID)
+		if pomodoroWithLock == nil {
+			continue
+		}
+		pomodoroWithLock.lock.Lock()
+		found := pomodoroWithLock.pomo != nil && pomodoroWithLock.pomo.HasMember(userID)
+		pomodoroWithLock.lock.Unlock()
+		if found {
+			return guild.Room(room.VoiceChannelID)
+		}
+	}
+	return RoomInfo{}, false
+}
 
-	//////////////////////////////
-	// 対象のVCチャンネル以外は無視 //
-	/////////////////////////////
+func (b *Bot) onVoiceStateUpdate(session *discordgo.Session, updated *discordgo.VoiceStateUpdate) {
+	b.handleVoiceStateUpdate(NewDiscord(session), updated)
+}
 
-	pomodoroVCChannelID := Info.GetChannelIDForPomodoroVC()
+func (b *Bot) handleVoiceStateUpdate(discord Discord, updated *discordgo.VoiceStateUpdate) {
+	// log.Printf("onVoiceStateUpdate: %#v", updated)
 
-	if updated.ChannelID == "" && updated.BeforeUpdate.ChannelID != pomodoroVCChannelID {
-		// 対象チャンネル以外からLeaveしたとき
-		SafeRemoveUserWithLock(updated.GuildID, updated.UserID)
+	guild, ok := b.LookupGuildInfo(updated.GuildID)
+	if !ok {
+		// 設定されていないギルド
 		return
 	}
-	if updated.BeforeUpdate == nil && updated.ChannelID != pomodoroVCChannelID {
-		// どこにも入っていない状態で対象チャンネル以外にJoinしたとき
-		return
+
+	beforeChannelID := ""
+	if updated.BeforeUpdate != nil {
+		beforeChannelID = updated.BeforeUpdate.ChannelID
+	} else if room, ok := b.findRoomWithMember(guild, updated.UserID); ok {
+		// 前の状態がキャッシュにないときは、メンバーとして入っているルームから抜けたことにする
+		beforeChannelID = room.VoiceChannelID
 	}
 
-	// 関係ないチャンネル間の移動
-	if updated.ChannelID != pomodoroVCChannelID && updated.BeforeUpdate.ChannelID != pomodoroVCChannelID {
+	// チャンネル移動以外の変更(mute, deafen 等)は無視
+	if beforeChannelID == updated.ChannelID {
 		return
 	}
 
-	// チャンネル移動以外の変更(mute, deafen 等)は無視
-	if updated.BeforeUpdate != nil && updated.BeforeUpdate.ChannelID == updated.ChannelID {
+	released := VoiceFlags{}
+	if updated.ChannelID != "" {
+		// VC にいる間しか解除できないので、保留していた解除をここで行う
+		released = b.applyPendingRelease(discord, guild, updated.UserID)
+	}
+
+	//////////////////////////////
+	// 対象のVCチャンネル以外は無視 //
+	/////////////////////////////
+
+	leftRoom, isLeave := guild.Room(beforeChannelID)
+	joinedRoom, isJoin := guild.Room(updated.ChannelID)
+
+	if !isLeave && !isJoin {
+		if updated.ChannelID == "" {
+			// 対象チャンネル以外からLeaveしたとき
+			if room, ok := b.findRoomWithMember(guild, updated.UserID); ok {
+				b.SafeRemoveUserWithLock(room.VoiceChannelID, updated.UserID)
+			}
+		}
+		// 関係ないチャンネル間の移動
 		return
 	}
 
-	user, err := session.User(updated.UserID)
+	user, err := discord.User(updated.UserID)
 	if err != nil {
 		log.Print("Error getting user: ", err)
 		return
@@ -477,28 +1163,28 @@ func onVoiceStateUpdate(session *discordgo.Session, updated *discordgo.VoiceStat
 	// Pomodoro //
 	//////////////
 
-	pomodoroVCChannel, err := session.Channel(pomodoroVCChannelID)
-	if err != nil {
-		log.Printf("Error in get channel: %s", err)
-		return
-	}
+	log.Printf("leave: %v, join: %v", isLeave, isJoin)
 
-	isJoin := false
-	if updated.ChannelID == pomodoroVCChannel.ID {
-		isJoin = true
+	// イベントの状態は解除する前のものなので、解除したものは元からの mute/deafen ではない
+	voice := voiceFlagsAfterRelease(updated.VoiceState, released)
+	if isLeave {
+		if pomodoro, err := b.getPomodoroWithLock(discord, guild, leftRoom); err != nil {
+			log.Println(err)
+		} else {
+			if kept, ok := pomodoro.RemoveMember(user.ID); ok {
+				// イベントの状態には前のルームで bot が付けた mute/deafen が含まれる
+				voice = kept
+			}
+			b.releaseOrUnlockPomodoro(pomodoro, leftRoom.VoiceChannelID)
+		}
 	}
-	log.Printf("%v", isJoin)
 
-	if pomodoro, err := getPomodoroWithLock(session, pomodoroVCChannel.GuildID, Info.GetChannelIDForNotification()); err != nil {
-		log.Println(err)
-		return
-	} else {
-		if isJoin {
-			defer unlockPomodoro(pomodoroVCChannel.GuildID)
-			pomodoro.AddUser(*user)
+	if isJoin {
+		if pomodoro, err := b.getPomodoroWithLock(discord, guild, joinedRoom); err != nil {
+			log.Println(err)
 		} else {
-			defer releaseOrUnlockPomodoro(pomodoro, pomodoroVCChannel.GuildID)
-			pomodoro.RemoveMember(user.ID)
+			defer b.unlockPomodoro(joinedRoom.VoiceChannelID)
+			pomodoro.AddUser(*user, voice)
 		}
 	}
 }
@@ -0,0 +1,1171 @@
+package pomodoro
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pollenjp/pomodoro-bot/app/clock"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+const (
+	testGuildID        = "guild"
+	testTextChannelID  = "text"
+	testVoiceChannelID = "vc"
+)
+
+func testGuildInfo() GuildInfo {
+	return GuildInfo{
+		GuildID:                  testGuildID,
+		ChannelIDForNotification: testTextChannelID,
+		Rooms: []RoomInfo{
+			{VoiceChannelID: testVoiceChannelID},
+			{VoiceChannelID: "vc2", ChannelIDForNotification: "text2"},
+		},
+	}
+}
+
+// before から after に移動したときのイベント ("" は VC にいない)
+func voiceStateUpdate(guildID GuildID, userID UserID, before ChannelID, after ChannelID) *discordgo.VoiceStateUpdate {
+	updated := &discordgo.VoiceStateUpdate{
+		VoiceState: &discordgo.VoiceState{GuildID: guildID, ChannelID: after, UserID: userID},
+	}
+	if before != "" {
+		updated.BeforeUpdate = &discordgo.VoiceState{GuildID: guildID, ChannelID: before, UserID: userID}
+	}
+	return updated
+}
+
+type testPomodoro struct {
+	*Pomodoro
+	clock   *clock.Fake
+	discord *fakeDiscord
+	store   *storage.Memory
+}
+
+func newTestPomodoro(t *testing.T, schedule string) *testPomodoro {
+	t.Helper()
+
+	discord := newFakeDiscord()
+	c := clock.NewFake(time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC))
+	guild := testGuildInfo()
+	room, _ := guild.Room(testVoiceChannelID)
+	store := storage.NewMemory()
+	p, err := newBot(store).newPomodoro(context.Background(), c, discord, guild, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+
+	s, err := ParseSchedule(schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	p.call(func() {
+		p.reminders = Reminders{
+			PhaseKindBreak: {10 * time.Second},
+		}
+	})
+
+	return &testPomodoro{
+		Pomodoro: p,
+		clock:    c,
+		discord:  discord,
+		store:    store,
+	}
+}
+
+// 時間を進めて、発火したタイマーの処理が終わるのを待つ
+func (tp *testPomodoro) advance(d time.Duration) {
+	// タイマーの関数は run goroutine がイベントを処理し終えるまで戻らないので、
+	// 途中で次のフェーズのタイマーが設定されてもまとめて進む
+	tp.clock.Advance(d)
+	tp.GetStatus()
+}
+
+func (tp *testPomodoro) assertStatus(t *testing.T, want PomodoroStatus) {
+	t.Helper()
+	if got := tp.GetStatus(); got != want {
+		t.Fatalf("status = %v, want %v", got, want)
+	}
+}
+
+func (tp *testPomodoro) assertMutedAndDeafened(t *testing.T, userID UserID, want bool) {
+	t.Helper()
+	mute, deaf := tp.discord.MutedAndDeafened(testGuildID, userID)
+	if mute != want || deaf != want {
+		t.Fatalf("%s: mute = %v, deaf = %v, want %v", userID, mute, deaf, want)
+	}
+}
+
+func (tp *testPomodoro) assertMessageCount(t *testing.T, want int) []fakeMessage {
+	t.Helper()
+	messages := tp.discord.Messages()
+	if len(messages) != want {
+		t.Fatalf("got %d messages, want %d: %+v", len(messages), want, messages)
+	}
+	return messages
+}
+
+func TestPomodoroFullCycle(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.assertMutedAndDeafened(t, "alice", true)
+	// welcome + task start
+	messages := tp.assertMessageCount(t, 2)
+	if !mentions(messages[1], "alice") {
+		t.Errorf("task start message does not mention the member: %q", messages[1].Content)
+	}
+
+	tp.advance(25*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.assertMutedAndDeafened(t, "alice", false)
+	tp.assertMessageCount(t, 3)
+
+	// warning 10s before the end of the break
+	tp.advance(5*time.Minute - 10*time.Second)
+	messages = tp.assertMessageCount(t, 4)
+	if !strings.Contains(messages[3].Content, "10s") {
+		t.Errorf("warning message does not contain the offset: %q", messages[3].Content)
+	}
+
+	tp.advance(10 * time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.assertMutedAndDeafened(t, "alice", true)
+	tp.assertMessageCount(t, 5)
+
+	tp.Stop()
+	tp.assertStatus(t, PomodoroStatusStop)
+	tp.assertMutedAndDeafened(t, "alice", false)
+	messages = tp.assertMessageCount(t, 6)
+	if !strings.Contains(messages[5].Content, "Pomodoro is over!") {
+		t.Errorf("unexpected stop message: %q", messages[5].Content)
+	}
+
+	// 止めた後にタイマーが残っていない
+	tp.advance(time.Hour)
+	tp.assertStatus(t, PomodoroStatusStop)
+	tp.assertMessageCount(t, 6)
+	if n := tp.clock.PendingTimers(); n != 0 {
+		t.Errorf("%d timers are still pending after stop", n)
+	}
+}
+
+func TestPomodoroBreakAnnouncesDuration(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 10b")
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.advance(25 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	messages := tp.discord.Messages()
+	if last := messages[len(messages)-1].Content; !strings.Contains(last, "時間は10分間しかないのんな") {
+		t.Errorf("break start = %q", last)
+	}
+}
+
+func TestPomodoroAnnouncementsInEnglish(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	tp.call(func() { tp.locale = "en" })
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	messages := tp.discord.Messages()
+	task := messages[len(messages)-1].Content
+	for _, want := range []string{"The task will end in 25 minutes.", "The task will end at 2022/10/01 09:25."} {
+		if !strings.Contains(task, want) {
+			t.Errorf("task start %q does not contain %q", task, want)
+		}
+	}
+
+	tp.advance(25 * time.Minute)
+	messages = tp.discord.Messages()
+	breakStart := messages[len(messages)-1].Content
+	for _, want := range []string{"The break will end in 5 minutes.", "The break will end at 2022/10/01 09:30."} {
+		if !strings.Contains(breakStart, want) {
+			t.Errorf("break start %q does not contain %q", breakStart, want)
+		}
+	}
+}
+
+// 終わる時刻はギルドのタイムゾーンで知らせる
+func TestPomodoroAnnouncementsInGuildTimeZone(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	tp.call(func() {
+		tp.locale = "en"
+		tp.location = time.FixedZone("JST", 9*60*60)
+	})
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	if last := tp.lastMessage(t).Content; !strings.Contains(last, "The task will end at 2022/10/01 18:25.") {
+		t.Errorf("task start = %q", last)
+	}
+
+	if err := tp.Extend(3 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if last := tp.lastMessage(t).Content; !strings.Contains(last, "This phase will end at 2022/10/01 18:28.") {
+		t.Errorf("extend = %q", last)
+	}
+
+	if err := tp.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	tp.advance(2 * time.Minute)
+	if err := tp.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if last := tp.lastMessage(t).Content; !strings.Contains(last, "This phase will end at 2022/10/01 18:30.") {
+		t.Errorf("resume = %q", last)
+	}
+
+	tp.advance(30 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	if last := tp.lastMessage(t).Content; !strings.Contains(last, "The break will end at 2022/10/01 18:35.") {
+		t.Errorf("break start = %q", last)
+	}
+}
+
+// 一時停止中にスキップして休憩に入っても、一度 mute し直したりしない
+func TestPomodoroBreakDoesNotEnforce(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.advance(10 * time.Minute)
+	if err := tp.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertMutedAndDeafened(t, "alice", false)
+	changes := tp.discord.VoiceChanges("alice")
+
+	if err := tp.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.assertMutedAndDeafened(t, "alice", false)
+	if got := tp.discord.VoiceChanges("alice"); got != changes {
+		t.Errorf("changed mute/deafen %d times at the start of the break", got-changes)
+	}
+}
+
+func TestPomodoroBreakUnmutesAllMembers(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.AddUser(discordgo.User{ID: "bob"}, VoiceFlags{})
+	tp.assertMutedAndDeafened(t, "alice", true)
+	tp.assertMutedAndDeafened(t, "bob", true)
+	before := len(tp.discord.Messages())
+
+	tp.advance(25 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.assertMutedAndDeafened(t, "alice", false)
+	tp.assertMutedAndDeafened(t, "bob", false)
+
+	messages := tp.assertMessageCount(t, before+1)
+	msg := messages[before]
+	if msg.ChannelID != testTextChannelID {
+		t.Errorf("message was sent to %s, want %s", msg.ChannelID, testTextChannelID)
+	}
+	for _, userID := range []UserID{"alice", "bob"} {
+		if !mentions(msg, userID) {
+			t.Errorf("break message does not mention %s: %q", userID, msg.Content)
+		}
+	}
+}
+
+func TestHandleVoiceStateUpdate(t *testing.T) {
+	const vcID = testVoiceChannelID
+	b := newTestBot(t)
+	discord := newFakeDiscord()
+
+	// 設定されていないギルドは無視する
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate("other", "bob", "", vcID))
+	if mute, deaf := discord.MutedAndDeafened("other", "bob"); mute || deaf {
+		t.Errorf("bob in an unconfigured guild was muted or deafened")
+	}
+	if len(discord.Messages()) != 0 {
+		t.Errorf("messages were sent for an unconfigured guild: %+v", discord.Messages())
+	}
+
+	// join
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", vcID))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); !mute || !deaf {
+		t.Errorf("alice: mute = %v, deaf = %v after joining", mute, deaf)
+	}
+
+	// leave
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", vcID, ""))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); mute || deaf {
+		t.Errorf("alice: mute = %v, deaf = %v after leaving", mute, deaf)
+	}
+	if pp := b.lookupPomodoroWithLock(vcID); pp == nil || pp.pomo != nil {
+		t.Error("pomodoro was not released after the last member left")
+	}
+}
+
+// 前の状態がないイベントでも、入っているルームから抜けたことにする
+func TestHandleVoiceStateUpdateWithoutBeforeUpdate(t *testing.T) {
+	b := newTestBot(t)
+	discord := newFakeDiscord()
+
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", testVoiceChannelID))
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", "", "vc2"))
+	t.Cleanup(func() {
+		b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", testVoiceChannelID, ""))
+	})
+
+	// leave
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", ""))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); mute || deaf {
+		t.Errorf("alice: mute = %v, deaf = %v after leaving", mute, deaf)
+	}
+	if _, ok := b.findRoomWithMember(testGuildInfo(), "alice"); ok {
+		t.Error("alice is still a member after leaving")
+	}
+	if pp := b.lookupPomodoroWithLock(testVoiceChannelID); pp == nil || pp.pomo != nil {
+		t.Error("pomodoro was not released after the last member left")
+	}
+
+	// 別のルームへの移動
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", "", testVoiceChannelID))
+	if pp := b.lookupPomodoroWithLock("vc2"); pp == nil || pp.pomo != nil {
+		t.Error("the second room was not released after the last member moved")
+	}
+	if pp := b.lookupPomodoroWithLock(testVoiceChannelID); pp == nil || pp.pomo == nil || !pp.pomo.HasMember("bob") {
+		t.Error("bob did not join the first room")
+	}
+}
+
+func TestHandleVoiceStateUpdateRooms(t *testing.T) {
+	b := newTestBot(t)
+	discord := newFakeDiscord()
+
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", testVoiceChannelID))
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", "", "vc2"))
+	t.Cleanup(func() {
+		b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "vc2", ""))
+		b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", "vc2", ""))
+	})
+
+	// VC ごとに別の Pomodoro が動き、それぞれの通知チャンネルに送る
+	room1 := b.lookupPomodoroWithLock(testVoiceChannelID)
+	room2 := b.lookupPomodoroWithLock("vc2")
+	if room1 == nil || room2 == nil || room1.pomo == nil || room2.pomo == nil || room1.pomo == room2.pomo {
+		t.Fatal("each room does not have its own pomodoro")
+	}
+	if !room1.pomo.HasMember("alice") || room1.pomo.HasMember("bob") {
+		t.Error("members of the first room are wrong")
+	}
+	if !room2.pomo.HasMember("bob") || room2.pomo.HasMember("alice") {
+		t.Error("members of the second room are wrong")
+	}
+	for _, msg := range discord.Messages() {
+		switch {
+		case mentions(msg, "alice") && msg.ChannelID != testTextChannelID:
+			t.Errorf("message for alice was sent to %s", msg.ChannelID)
+		case mentions(msg, "bob") && msg.ChannelID != "text2":
+			t.Errorf("message for bob was sent to %s", msg.ChannelID)
+		}
+	}
+
+	// 別のルームへの移動
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", testVoiceChannelID, "vc2"))
+	if room1.pomo != nil {
+		t.Error("the first room was not released after the last member moved")
+	}
+	if !room2.pomo.HasMember("alice") {
+		t.Error("alice did not join the second room")
+	}
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); !mute || !deaf {
+		t.Errorf("alice: mute = %v, deaf = %v after moving", mute, deaf)
+	}
+
+	// ルーム以外の VC への出入りは無視する
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "carol", "", "lobby"))
+	if pp := b.lookupPomodoroWithLock("lobby"); pp != nil {
+		t.Error("pomodoro was created for a VC that is not a room")
+	}
+}
+
+func TestPomodoroPauseResume(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.advance(10 * time.Minute)
+
+	if err := tp.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertMutedAndDeafened(t, "alice", false)
+	if err := tp.Pause(); err == nil {
+		t.Error("Pause() twice did not fail")
+	}
+
+	// 一時停止中は進まない
+	tp.advance(time.Hour)
+	tp.assertStatus(t, PomodoroStatusTask)
+
+	if err := tp.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertMutedAndDeafened(t, "alice", true)
+
+	tp.advance(15*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+}
+
+// 休憩中に一時停止しても mute せず、再開後は残りの休憩時間で次のタスクに進む
+func TestPomodoroPauseResumeDuringBreak(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	tp.call(func() { tp.location = time.UTC })
+
+	// 一時停止するものがない
+	if err := tp.Pause(); err == nil {
+		t.Error("Pause() before start did not fail")
+	}
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.AddUser(discordgo.User{ID: "bob"}, VoiceFlags{})
+	if err := tp.Resume(); err == nil {
+		t.Error("Resume() without pause did not fail")
+	}
+	tp.advance(27 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+
+	if err := tp.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertMutedAndDeafened(t, "alice", false)
+	paused := tp.lastMessage(t)
+	if !strings.Contains(paused.Content, "残りは 3m0s なのん") || !mentions(paused, "alice") || !mentions(paused, "bob") {
+		t.Errorf("pause = %q", paused.Content)
+	}
+
+	tp.advance(time.Hour)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	if err := tp.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertMutedAndDeafened(t, "alice", false)
+	resumed := tp.lastMessage(t)
+	if !strings.Contains(resumed.Content, "2022/10/01 10:30 までなのん") || !mentions(resumed, "alice") || !mentions(resumed, "bob") {
+		t.Errorf("resume = %q", resumed.Content)
+	}
+
+	tp.advance(3*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.assertMutedAndDeafened(t, "alice", true)
+}
+
+func TestPomodoroSkipAndExtend(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+
+	if err := tp.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+
+	if err := tp.Extend(3 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	tp.advance(8*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+}
+
+// skip/extend は前のタイマーを止めてから張り直す
+func TestPomodoroSkipAndExtendRearmTimer(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	if err := tp.Skip(); err == nil {
+		t.Error("Skip() before start did not fail")
+	}
+	if err := tp.Extend(time.Minute); err == nil {
+		t.Error("Extend() before start did not fail")
+	}
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.AddUser(discordgo.User{ID: "bob"}, VoiceFlags{})
+	if err := tp.Extend(0); err == nil {
+		t.Error("Extend(0) did not fail")
+	}
+
+	// タスクの途中で延長する
+	tp.advance(10 * time.Minute)
+	if err := tp.Extend(5 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if n := tp.clock.PendingTimers(); n != 1 {
+		t.Errorf("%d timers are pending after extend, want 1", n)
+	}
+	if last := tp.lastMessage(t); !mentions(last, "alice") || !mentions(last, "bob") {
+		t.Errorf("extend does not mention all members: %q", last.Content)
+	}
+	tp.advance(20*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+
+	// 休憩の途中でスキップすると、次のタスクはそこから 25 分
+	tp.advance(2 * time.Minute)
+	if err := tp.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.assertMutedAndDeafened(t, "alice", true)
+	tp.advance(25*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+
+	// 一時停止中にスキップすると一時停止も解け、次のタスクのタイマーが動く
+	if err := tp.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tp.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.assertMutedAndDeafened(t, "alice", true)
+
+	// 一時停止中の延長は再開後の残り時間に足す
+	tp.advance(10 * time.Minute)
+	if err := tp.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tp.Extend(5 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if n := tp.clock.PendingTimers(); n != 0 {
+		t.Errorf("%d timers are pending while paused", n)
+	}
+	tp.advance(time.Hour)
+	if err := tp.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	tp.advance(20*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+}
+
+func TestPomodoroAdvanceAcrossPhases(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	// タスク → 休憩 → タスクを一度に進める
+	tp.advance(31 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.assertMutedAndDeafened(t, "alice", true)
+
+	// 2 つ目のタスクは 30 分に始まっている
+	tp.advance(24*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+
+	// 何周もまとめて進める
+	tp.advance(5*time.Minute + 3*30*time.Minute)
+	tp.assertStatus(t, PomodoroStatusTask)
+	if phases, _ := tp.store.LoadPhases(testGuildID, time.Time{}); len(phases) != 10 {
+		t.Errorf("recorded %d phases, want 10", len(phases))
+	}
+}
+
+func TestPomodoroLongBreak(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	if err := tp.SetSchedule(DefaultSchedule(25*time.Minute, 5*time.Minute, 15*time.Minute, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	// 2 回タスクを終えるごとに長い休憩を取る
+	// 長い休憩の後は数え直す
+	for round := 0; round < 2; round++ {
+		tp.assertStatus(t, PomodoroStatusTask)
+		tp.advance(25 * time.Minute)
+		tp.assertStatus(t, PomodoroStatusBreakTime)
+		messages := tp.discord.Messages()
+		if last := messages[len(messages)-1].Content; !strings.Contains(last, "長い休憩まで 1/2 なのん") {
+			t.Errorf("round %d: break start = %q", round, last)
+		}
+		tp.advance(5 * time.Minute)
+		tp.assertStatus(t, PomodoroStatusTask)
+
+		start := len(tp.discord.Messages())
+		tp.advance(25 * time.Minute)
+		tp.assertStatus(t, PomodoroStatusLongBreakTime)
+		tp.assertMutedAndDeafened(t, "alice", false)
+		messages = tp.discord.Messages()[start:]
+		if len(messages) != 1 {
+			t.Fatalf("round %d: got %d messages, want 1: %+v", round, len(messages), messages)
+		}
+		for _, want := range []string{"長い休憩なのん", "長い休憩は15分間なのん!", "長い休憩まで 2/2 なのん"} {
+			if !strings.Contains(messages[0].Content, want) {
+				t.Errorf("round %d: %q does not contain %q", round, messages[0].Content, want)
+			}
+		}
+
+		tp.advance(15*time.Minute - time.Second)
+		tp.assertStatus(t, PomodoroStatusLongBreakTime)
+		tp.advance(time.Second)
+	}
+	tp.assertStatus(t, PomodoroStatusTask)
+}
+
+func TestPomodoroFinishesAfterCycles(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	if err := tp.SetCycles(2); err != nil {
+		t.Fatal(err)
+	}
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	for i := 0; i < 2; i++ {
+		tp.advance(25 * time.Minute)
+		tp.assertStatus(t, PomodoroStatusBreakTime)
+		tp.advance(5 * time.Minute)
+	}
+	tp.assertStatus(t, PomodoroStatusStop)
+	tp.assertMutedAndDeafened(t, "alice", false)
+
+	if n := tp.clock.PendingTimers(); n != 0 {
+		t.Errorf("%d timers are still pending after finish", n)
+	}
+}
+
+// ギルドの cycles で生成した Pomodoro は長い休憩も 1 回の休憩として数え、
+// 終わったときにこなしたタスクの数を知らせる
+func TestPomodoroFinishesAfterGuildCycles(t *testing.T) {
+	b := newTestBot(t)
+	config, err := b.GetGuildConfig(testGuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.TaskDuration = 25 * time.Minute
+	config.BreakDuration = 5 * time.Minute
+	config.LongBreakDuration = 15 * time.Minute
+	config.LongBreakInterval = 2
+	config.Cycles = 3
+	if err := b.SetGuildConfig(testGuildID, config); err != nil {
+		t.Fatal(err)
+	}
+
+	guild := testGuildInfo()
+	room, _ := guild.Room(testVoiceChannelID)
+	c := clock.NewFake(time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC))
+	discord := newFakeDiscord()
+	p, err := b.newPomodoro(context.Background(), c, discord, guild, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	tp := &testPomodoro{Pomodoro: p, clock: c, discord: discord}
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	for _, phase := range []struct {
+		d      time.Duration
+		status PomodoroStatus
+	}{
+		{25 * time.Minute, PomodoroStatusTask},
+		{5 * time.Minute, PomodoroStatusBreakTime},
+		{25 * time.Minute, PomodoroStatusTask},
+		{15 * time.Minute, PomodoroStatusLongBreakTime},
+		{25 * time.Minute, PomodoroStatusTask},
+		{5 * time.Minute, PomodoroStatusBreakTime},
+	} {
+		tp.assertStatus(t, phase.status)
+		tp.advance(phase.d - time.Second)
+		tp.assertStatus(t, phase.status)
+		tp.advance(time.Second)
+	}
+	tp.assertStatus(t, PomodoroStatusStop)
+	tp.assertMutedAndDeafened(t, "alice", false)
+
+	last := tp.lastMessage(t)
+	for _, want := range []string{"スケジュール終了なのん!", "今回は3回タスクをこなしたのん!"} {
+		if !strings.Contains(last.Content, want) {
+			t.Errorf("closing summary %q does not contain %q", last.Content, want)
+		}
+	}
+	if !mentions(last, "alice") {
+		t.Errorf("closing summary does not mention the member: %q", last.Content)
+	}
+	if n := tp.clock.PendingTimers(); n != 0 {
+		t.Errorf("%d timers are still pending after finish", n)
+	}
+}
+
+func TestPomodoroPersistsSession(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	tp.AddUser(discordgo.User{ID: "alice", Username: "Alice"}, VoiceFlags{})
+	sessions, err := tp.store.LoadSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(sessions))
+	}
+	s := sessions[0]
+	if s.VoiceChannelID != testVoiceChannelID || s.Phase != storage.PhaseTask || !s.PhaseEndAt.Equal(tp.clock.Now().Add(25*time.Minute)) {
+		t.Errorf("unexpected session: %+v", s)
+	}
+	if len(s.Members) != 1 || s.Members[0] != (storage.Member{ID: "alice", Username: "Alice", Muted: true, Deafened: true}) {
+		t.Errorf("members = %+v", s.Members)
+	}
+
+	tp.advance(25 * time.Minute)
+	sessions, _ = tp.store.LoadSessions()
+	if len(sessions) != 1 || sessions[0].Phase != storage.PhaseBreak || sessions[0].CompletedTasks != 1 {
+		t.Errorf("session after the task = %+v", sessions)
+	}
+
+	tp.Stop()
+	sessions, _ = tp.store.LoadSessions()
+	if len(sessions) != 0 {
+		t.Errorf("session was not deleted after stop: %+v", sessions)
+	}
+}
+
+func TestPomodoroRecordsPhases(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	start := tp.clock.Now()
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.advance(10 * time.Minute)
+	if err := tp.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	tp.advance(7 * time.Minute)
+	if err := tp.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	tp.advance(15 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.advance(time.Minute)
+	if err := tp.Skip(); err != nil {
+		t.Fatal(err)
+	}
+
+	phases, err := tp.store.LoadPhases("", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(phases) != 2 {
+		t.Fatalf("got %d phases, want 2: %+v", len(phases), phases)
+	}
+
+	task := phases[0]
+	if task.Phase != storage.PhaseTask || task.Skipped || task.GuildID != testGuildID || task.VoiceChannelID != testVoiceChannelID {
+		t.Errorf("unexpected task record: %+v", task)
+	}
+	// 一時停止していた 7 分は含まない
+	if task.Duration != 25*time.Minute || !task.StartedAt.Equal(start) || !task.EndedAt.Equal(start.Add(32*time.Minute)) {
+		t.Errorf("task: duration = %s, %s - %s", task.Duration, task.StartedAt, task.EndedAt)
+	}
+	if len(task.Members) != 1 || task.Members[0] != "alice" {
+		t.Errorf("task members = %v", task.Members)
+	}
+
+	brk := phases[1]
+	if brk.Phase != storage.PhaseBreak || !brk.Skipped || brk.Duration != time.Minute {
+		t.Errorf("unexpected break record: %+v", brk)
+	}
+}
+
+func TestPomodoroRecordsParticipants(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.AddUser(discordgo.User{ID: "bob"}, VoiceFlags{})
+	tp.advance(5 * time.Minute)
+	tp.AddUser(discordgo.User{ID: "carol"}, VoiceFlags{})
+	tp.advance(5 * time.Minute)
+	tp.RemoveMember("bob")
+	tp.advance(15 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+
+	// 次のタスクは途中で止める
+	tp.advance(5 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.advance(10 * time.Minute)
+	tp.Stop()
+
+	phases, err := tp.store.LoadPhases("", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(phases) != 3 {
+		t.Fatalf("got %d phases, want 3: %+v", len(phases), phases)
+	}
+
+	got := map[UserID]storage.Participant{}
+	for _, p := range phases[0].Participants {
+		got[p.UserID] = p
+	}
+	want := map[UserID]storage.Participant{
+		"alice": {UserID: "alice", Focused: 25 * time.Minute, Completed: true},
+		"bob":   {UserID: "bob", Focused: 10 * time.Minute, Left: true},
+		"carol": {UserID: "carol", Focused: 20 * time.Minute},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("participants of the first task = %+v, want %+v", got, want)
+	}
+
+	stopped := phases[2]
+	if stopped.Phase != storage.PhaseTask || !stopped.Stopped {
+		t.Fatalf("unexpected stopped record: %+v", stopped)
+	}
+	for _, p := range stopped.Participants {
+		if p.Completed || p.Focused != 10*time.Minute {
+			t.Errorf("participant of the stopped task = %+v", p)
+		}
+	}
+}
+
+func TestShutdownPomodoros(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+	discord := newFakeDiscord()
+
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", testVoiceChannelID))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); !mute || !deaf {
+		t.Fatalf("alice: mute = %v, deaf = %v", mute, deaf)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.shutdownPomodoros(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); mute || deaf {
+		t.Errorf("alice: mute = %v, deaf = %v after shutdown", mute, deaf)
+	}
+	messages := discord.Messages()
+	if last := messages[len(messages)-1]; last.ChannelID != testTextChannelID || !mentions(last, "alice") {
+		t.Errorf("restart notice = %+v", last)
+	}
+	if pp := b.lookupPomodoroWithLock(testVoiceChannelID); pp.pomo != nil {
+		t.Error("pomodoro was not released")
+	}
+	// 再起動後に再開できるようにセッションは残す
+	if sessions, _ := store.LoadSessions(); len(sessions) != 1 {
+		t.Errorf("sessions after shutdown = %+v", sessions)
+	}
+
+	// 終了処理の後は新しく始めない
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", "", testVoiceChannelID))
+	if mute, _ := discord.MutedAndDeafened(testGuildID, "bob"); mute {
+		t.Error("bob was muted after shutdown")
+	}
+}
+
+func TestPendingReleaseIsAppliedOnJoin(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+	discord := newFakeDiscord()
+
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", testVoiceChannelID))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); !mute || !deaf {
+		t.Fatalf("alice: mute = %v, deaf = %v", mute, deaf)
+	}
+
+	// VC を抜けた後は解除できない
+	discord.SetDisconnected("alice", true)
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", testVoiceChannelID, ""))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); !mute || !deaf {
+		t.Fatalf("alice was released while disconnected: mute = %v, deaf = %v", mute, deaf)
+	}
+	if releases, _ := store.LoadPendingReleases(testGuildID); len(releases) != 1 || releases[0].UserID != "alice" {
+		t.Fatalf("pending releases = %+v", releases)
+	}
+
+	// ルーム以外の VC に入っても解除する
+	discord.SetDisconnected("alice", false)
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", "lobby"))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); mute || deaf {
+		t.Errorf("alice: mute = %v, deaf = %v after joining", mute, deaf)
+	}
+	if releases, _ := store.LoadPendingReleases(testGuildID); len(releases) != 0 {
+		t.Errorf("pending releases after joining = %+v", releases)
+	}
+	if dms := discord.DirectMessages("alice"); len(dms) != 1 {
+		t.Errorf("got %d direct messages, want 1", len(dms))
+	}
+
+	// 保留がなければ DM しない
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "lobby", "lobby2"))
+	if dms := discord.DirectMessages("alice"); len(dms) != 1 {
+		t.Errorf("got %d direct messages after moving, want 1", len(dms))
+	}
+}
+
+// deafen の解除だけ失敗する
+type undeafenFailingDiscord struct {
+	*fakeDiscord
+	fail bool
+}
+
+func (d *undeafenFailingDiscord) DeafenMember(guildID GuildID, userID UserID, deaf bool) error {
+	if d.fail && !deaf {
+		return fmt.Errorf("failed to undeafen")
+	}
+	return d.fakeDiscord.DeafenMember(guildID, userID, deaf)
+}
+
+// mute だけ解除できたときは、次に入ったときに deafen だけを解除する
+func TestPendingReleaseIsPartiallyApplied(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+	discord := &undeafenFailingDiscord{fakeDiscord: newFakeDiscord(), fail: true}
+	discord.MuteMember(testGuildID, "alice", true)
+	discord.DeafenMember(testGuildID, "alice", true)
+	if err := store.SavePendingRelease(storage.PendingRelease{GuildID: testGuildID, UserID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", "lobby"))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); mute || !deaf {
+		t.Fatalf("alice: mute = %v, deaf = %v", mute, deaf)
+	}
+	releases, _ := store.LoadPendingReleases(testGuildID)
+	if len(releases) != 1 || !releases[0].KeepMute || releases[0].KeepDeaf {
+		t.Fatalf("pending releases = %+v", releases)
+	}
+	if dms := discord.DirectMessages("alice"); len(dms) != 0 {
+		t.Errorf("got %d direct messages, want 0", len(dms))
+	}
+
+	// 自分で mute した後に入り直しても mute は解除しない
+	discord.MuteMember(testGuildID, "alice", true)
+	changes := discord.VoiceChanges("alice")
+	discord.fail = false
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", "lobby"))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); !mute || deaf {
+		t.Errorf("alice: mute = %v, deaf = %v after rejoining", mute, deaf)
+	}
+	if n := discord.VoiceChanges("alice") - changes; n != 1 {
+		t.Errorf("changed voice of alice %d times, want 1", n)
+	}
+	if releases, _ := store.LoadPendingReleases(testGuildID); len(releases) != 0 {
+		t.Errorf("pending releases after rejoining = %+v", releases)
+	}
+}
+
+func TestPendingReleaseOnRejoinDuringTask(t *testing.T) {
+	b := newTestBot(t)
+	discord := newFakeDiscord()
+
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", "", testVoiceChannelID))
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", "", testVoiceChannelID))
+	t.Cleanup(func() {
+		b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "bob", testVoiceChannelID, ""))
+	})
+
+	discord.SetDisconnected("alice", true)
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", testVoiceChannelID, ""))
+	discord.SetDisconnected("alice", false)
+
+	// 入り直したときのイベントには bot が付けた mute/deafen が残っている
+	rejoined := voiceStateUpdate(testGuildID, "alice", "", testVoiceChannelID)
+	rejoined.Mute = true
+	rejoined.Deaf = true
+	b.handleVoiceStateUpdate(discord, rejoined)
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); !mute || !deaf {
+		t.Errorf("alice during task: mute = %v, deaf = %v", mute, deaf)
+	}
+
+	// 解除したものは元からの mute/deafen として残さない
+	b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", testVoiceChannelID, ""))
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); mute || deaf {
+		t.Errorf("alice after leaving: mute = %v, deaf = %v", mute, deaf)
+	}
+}
+
+func TestPomodoroKeepsPreexistingServerMute(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	// bob はモデレーターに server mute されている
+	tp.discord.MuteMember(testGuildID, "bob", true)
+	tp.AddUser(discordgo.User{ID: "bob"}, VoiceFlags{Mute: true})
+	tp.assertMutedAndDeafened(t, "bob", true)
+
+	tp.advance(25 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.assertMutedAndDeafened(t, "alice", false)
+	if mute, deaf := tp.discord.MutedAndDeafened(testGuildID, "bob"); !mute || deaf {
+		t.Errorf("bob in break: mute = %v, deaf = %v, want true, false", mute, deaf)
+	}
+	sessions, _ := tp.store.LoadSessions()
+	if len(sessions) != 1 || len(sessions[0].Members) != 2 || !sessions[0].Members[1].KeepMute || sessions[0].Members[1].KeepDeaf {
+		t.Errorf("saved members = %+v", sessions)
+	}
+
+	tp.advance(5 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusTask)
+	// メンバーが start し直しても、bot が付けた mute を元の状態と取り違えない
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{Mute: true, Deaf: true})
+	tp.RemoveMember("alice")
+	tp.assertMutedAndDeafened(t, "alice", false)
+	kept, ok := tp.RemoveMember("bob")
+	if !ok || kept != (VoiceFlags{Mute: true}) {
+		t.Errorf("RemoveMember(bob) = %+v, %v", kept, ok)
+	}
+	if mute, deaf := tp.discord.MutedAndDeafened(testGuildID, "bob"); !mute || deaf {
+		t.Errorf("bob after leaving: mute = %v, deaf = %v, want true, false", mute, deaf)
+	}
+}
+
+func TestPomodoroEnforcement(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	tp.call(func() {
+		tp.roomEnforcement = EnforcementMute
+	})
+	if err := tp.store.SaveUserPrefs(storage.UserPrefs{GuildID: testGuildID, UserID: "bob", Enforcement: "none"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.AddUser(discordgo.User{ID: "bob"}, VoiceFlags{})
+	tp.assertStatus(t, PomodoroStatusTask)
+	if mute, deaf := tp.discord.MutedAndDeafened(testGuildID, "alice"); !mute || deaf {
+		t.Errorf("alice: mute = %v, deaf = %v, want true, false", mute, deaf)
+	}
+	tp.assertMutedAndDeafened(t, "bob", false)
+
+	// タスク中に設定を変えるとすぐに反映する
+	if err := tp.store.SaveUserPrefs(storage.UserPrefs{GuildID: testGuildID, UserID: "alice", Enforcement: "deafen"}); err != nil {
+		t.Fatal(err)
+	}
+	tp.RefreshMember("alice")
+	if mute, deaf := tp.discord.MutedAndDeafened(testGuildID, "alice"); mute || !deaf {
+		t.Errorf("alice after refresh: mute = %v, deaf = %v, want false, true", mute, deaf)
+	}
+
+	// bob には何もしないので、VC を抜けても解除を保留しない
+	tp.discord.SetDisconnected("bob", true)
+	tp.RemoveMember("bob")
+	if releases, _ := tp.store.LoadPendingReleases(testGuildID); len(releases) != 0 {
+		t.Errorf("pending releases = %+v", releases)
+	}
+
+	tp.advance(25 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.assertMutedAndDeafened(t, "alice", false)
+}
+
+// race detector で調べるため、一つのポモドーロを多くの goroutine から同時に操作する
+func TestPomodoroConcurrentOperations(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+
+	const workers = 8
+	const iterations = 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			user := discordgo.User{ID: fmt.Sprintf("user%d", w)}
+			for i := 0; i < iterations; i++ {
+				switch (w + i) % 8 {
+				case 0:
+					tp.AddUser(user, VoiceFlags{})
+				case 1:
+					tp.RemoveMember(user.ID)
+				case 2:
+					tp.Skip()
+				case 3:
+					tp.Stop()
+				case 4:
+					tp.Pause()
+					tp.Resume()
+				case 5:
+					tp.Extend(time.Minute)
+				case 6:
+					tp.clock.Advance(3 * time.Minute)
+				case 7:
+					tp.GetStatus()
+					tp.MemberCount()
+					tp.HasMember(user.ID)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// 全員が抜ければ止まって、誰も mute されていない
+	for w := 0; w < workers; w++ {
+		tp.RemoveMember(fmt.Sprintf("user%d", w))
+	}
+	tp.Stop()
+	tp.assertStatus(t, PomodoroStatusStop)
+	if n := tp.MemberCount(); n != 0 {
+		t.Errorf("%d members are left", n)
+	}
+	for w := 0; w < workers; w++ {
+		tp.assertMutedAndDeafened(t, fmt.Sprintf("user%d", w), false)
+	}
+	if n := tp.clock.PendingTimers(); n != 0 {
+		t.Errorf("%d timers are still pending after stop", n)
+	}
+}
+
+// VC の出入りとコマンドが同時に来ても、ルームのポモドーロは一つだけ使われる
+func TestHandleVoiceStateUpdateConcurrently(t *testing.T) {
+	b := newTestBot(t)
+	discord := newFakeDiscord()
+
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(userID UserID) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, userID, "", testVoiceChannelID))
+				b.forgetUserWithLock(testVoiceChannelID, "nobody")
+				b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, userID, testVoiceChannelID, ""))
+			}
+		}(fmt.Sprintf("user%d", w))
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		userID := fmt.Sprintf("user%d", w)
+		if _, ok := b.findRoomWithMember(testGuildInfo(), userID); ok {
+			t.Errorf("%s is still a member", userID)
+		}
+		if mute, deaf := discord.MutedAndDeafened(testGuildID, userID); mute || deaf {
+			t.Errorf("%s: mute = %v, deaf = %v", userID, mute, deaf)
+		}
+	}
+	if pomodoroWithLock := b.lookupPomodoroWithLock(testVoiceChannelID); pomodoroWithLock != nil {
+		pomodoroWithLock.lock.Lock()
+		defer pomodoroWithLock.lock.Unlock()
+		if pomodoroWithLock.pomo != nil {
+			t.Error("pomodoro was not released after everyone left")
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package pomodoro
+
+import (
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+// VC にいないユーザーの server mute/deafen は Discord の仕様で変更できない
+// 解除できなかったユーザーは保留にして、次にどこかの VC に入ったときに解除する
+
+// VoiceFlags はメンバーの server mute/deafen
+type VoiceFlags struct {
+	Mute bool
+	Deaf bool
+}
+
+func voiceFlagsOf(vs *discordgo.VoiceState) VoiceFlags {
+	if vs == nil {
+		return VoiceFlags{}
+	}
+	return VoiceFlags{Mute: vs.Mute, Deaf: vs.Deaf}
+}
+
+// bot が付けている mute/deafen (applied) を want に合わせる
+// 解除に失敗したものは保留にして、VC に入ったときに解除する
+// 合わせた後に bot が付けている mute/deafen を返す
+func setMemberVoice(discord Discord, store storage.Store, guildID GuildID, userID UserID, applied VoiceFlags, want VoiceFlags) VoiceFlags {
+	// 解除に失敗したもの
+	failed := VoiceFlags{}
+	if applied.Mute != want.Mute {
+		if err := discord.MuteMember(guildID, userID, want.Mute); err != nil {
+			log.Printf("Failed to set mute of %s to %v: %v", userID, want.Mute, err)
+			failed.Mute = !want.Mute
+		} else {
+			applied.Mute = want.Mute
+		}
+	}
+	if applied.Deaf != want.Deaf {
+		if err := discord.DeafenMember(guildID, userID, want.Deaf); err != nil {
+			log.Printf("Failed to set deafen of %s to %v: %v", userID, want.Deaf, err)
+			failed.Deaf = !want.Deaf
+		} else {
+			applied.Deaf = want.Deaf
+		}
+	}
+
+	if failed.Mute || failed.Deaf {
+		log.Printf("Release of %s is deferred until they join a voice channel", userID)
+		if err := store.SavePendingRelease(storage.PendingRelease{
+			GuildID:  guildID,
+			UserID:   userID,
+			KeepMute: !failed.Mute,
+			KeepDeaf: !failed.Deaf,
+		}); err != nil {
+			log.Printf("Failed to save pending release of %s: %v", userID, err)
+		}
+		// 保留にしたものはもう bot のものとして扱わない
+		applied.Mute = applied.Mute && !failed.Mute
+		applied.Deaf = applied.Deaf && !failed.Deaf
+	}
+	return applied
+}
+
+// 解除する前の vs の server mute/deafen から、解除したものを除く
+func voiceFlagsAfterRelease(vs *discordgo.VoiceState, released VoiceFlags) VoiceFlags {
+	voice := voiceFlagsOf(vs)
+	voice.Mute = voice.Mute && !released.Mute
+	voice.Deaf = voice.Deaf && !released.Deaf
+	return voice
+}
+
+// VC に入ったユーザーの保留していた mute/deafen を解除して DM で知らせる
+// 解除したものを返す
+func (b *Bot) applyPendingRelease(discord Discord, guild GuildInfo, userID UserID) VoiceFlags {
+	released := VoiceFlags{}
+	releases, err := b.store.LoadPendingReleases(guild.GuildID)
+	if err != nil {
+		log.Printf("Failed to load pending releases: %v", err)
+		return released
+	}
+	var release *storage.PendingRelease
+	for i := range releases {
+		if releases[i].UserID == userID {
+			release = &releases[i]
+			break
+		}
+	}
+	if release == nil {
+		return released
+	}
+
+	// 解除できたものは保留から外して、次に入ったときにもう一度解除しない
+	if !release.KeepMute {
+		if err := discord.MuteMember(guild.GuildID, userID, false); err != nil {
+			log.Printf("Failed to unmute %s: %v", userID, err)
+			return released
+		}
+		released.Mute = true
+		release.KeepMute = true
+		if !release.KeepDeaf {
+			b.replacePendingRelease(*release)
+		}
+	}
+	if !release.KeepDeaf {
+		if err := discord.DeafenMember(guild.GuildID, userID, false); err != nil {
+			log.Printf("Failed to undeafen %s: %v", userID, err)
+			return released
+		}
+		released.Deaf = true
+	}
+	if err := b.store.DeletePendingRelease(guild.GuildID, userID); err != nil {
+		log.Printf("Failed to delete pending release of %s: %v", userID, err)
+	}
+	log.Printf("Released server mute and deafen of %s", userID)
+
+	localizer := guild.localizer(b.bundle)
+	var msg string
+	messageID := "Released your server mute and deafen."
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
+	if err := discord.SendDirectMessage(userID, msg); err != nil {
+		log.Printf("Error sending direct message: %v", err)
+	}
+	return released
+}
+
+// 保留を r で置き換える
+// SavePendingRelease は残っている保留とまとめるので、消してから保存する
+func (b *Bot) replacePendingRelease(r storage.PendingRelease) {
+	if err := b.store.DeletePendingRelease(r.GuildID, r.UserID); err != nil {
+		log.Printf("Failed to delete pending release of %s: %v", r.UserID, err)
+		return
+	}
+	if err := b.store.SavePendingRelease(r); err != nil {
+		log.Printf("Failed to save pending release of %s: %v", r.UserID, err)
+	}
+}
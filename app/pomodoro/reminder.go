@@ -0,0 +1,126 @@
+package pomodoro
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// フェーズの種類ごとのリマインダー (フェーズ終了の何分前に通知するか)
+type Reminders map[PhaseKind][]time.Duration
+
+const (
+	PomodoroTaskReminders      = ""
+	PomodoroBreakReminders     = PomodoroWarningEndBreakDuration
+	PomodoroLongBreakReminders = PomodoroWarningEndBreakDuration
+)
+
+// ParseReminders parses a list of offsets before the end of a phase such as
+// "5m,1m" or "1m 10s". "none" (or an empty string) means no reminders.
+// The result is sorted from the earliest reminder (longest offset).
+func ParseReminders(s string) ([]time.Duration, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(fields) == 1 && fields[0] == "none" {
+		return []time.Duration{}, nil
+	}
+
+	offsets := []time.Duration{}
+	seen := map[time.Duration]bool{}
+	for _, field := range fields {
+		d, err := time.ParseDuration(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reminder %q: %w", field, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("reminder must be positive: %s", field)
+		}
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		offsets = append(offsets, d)
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] > offsets[j]
+	})
+	return offsets, nil
+}
+
+func FormatReminders(offsets []time.Duration) string {
+	if len(offsets) == 0 {
+		return "none"
+	}
+	s := []string{}
+	for _, d := range offsets {
+		s = append(s, d.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func DefaultReminders() (Reminders, error) {
+	reminders := Reminders{}
+	for kind, spec := range map[PhaseKind]string{
+		PhaseKindTask:      PomodoroTaskReminders,
+		PhaseKindBreak:     PomodoroBreakReminders,
+		PhaseKindLongBreak: PomodoroLongBreakReminders,
+	} {
+		offsets, err := ParseReminders(spec)
+		if err != nil {
+			return nil, err
+		}
+		reminders[kind] = offsets
+	}
+	return reminders, nil
+}
+
+func (r Reminders) Copy() Reminders {
+	c := Reminders{}
+	for kind, offsets := range r {
+		c[kind] = append([]time.Duration{}, offsets...)
+	}
+	return c
+}
+
+// フェーズが d 後に終わるときのリマインダーをセットする
+// d より長いリマインダーは既に過ぎているのでセットしない
+func (p *Pomodoro) armReminders(kind PhaseKind, d time.Duration) {
+	for _, offset := range p.reminders[kind] {
+		if offset >= d {
+			continue
+		}
+		p.scheduler.after(d-offset, timerEvent{
+			kind:      timerEventReminder,
+			phaseKind: kind,
+			offset:    offset,
+		})
+	}
+}
+
+// send message to all members
+func (p *Pomodoro) notifyPhaseEndSoon(kind PhaseKind, offset time.Duration) {
+	localizer := p.localizer()
+	var msg string
+	messageID := "The task will end soon!"
+	switch kind {
+	case PhaseKindBreak:
+		messageID = "The break time will end soon!"
+	case PhaseKindLongBreak:
+		messageID = "The long break will end soon!"
+	}
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: messageID,
+		TemplateData: map[string]interface{}{
+			"Duration": offset.String(),
+		},
+	}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
+	p.messageWithAllMembersMention(msg)
+}
@@ -0,0 +1,155 @@
+package pomodoro
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseReminders(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want []time.Duration
+	}{
+		{"5m,1m", []time.Duration{5 * time.Minute, time.Minute}},
+		// 長い順に並べて、重複は除く
+		{"10s 1m, 5m,1m", []time.Duration{5 * time.Minute, time.Minute, 10 * time.Second}},
+		{"none", []time.Duration{}},
+		{"", []time.Duration{}},
+	} {
+		got, err := ParseReminders(tt.spec)
+		if err != nil {
+			t.Errorf("ParseReminders(%q) = %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseReminders(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+		again, err := ParseReminders(FormatReminders(got))
+		if err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("round trip of %q = %v, %v", tt.spec, again, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		spec string
+		err  string
+	}{
+		{"5", `invalid reminder "5"`},
+		{"1m,soon", `invalid reminder "soon"`},
+		{"none,1m", `invalid reminder "none"`},
+		{"0s", "reminder must be positive: 0s"},
+		{"1m,-30s", "reminder must be positive: -30s"},
+	} {
+		_, err := ParseReminders(tt.spec)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("ParseReminders(%q) = %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+// content を含むメッセージの数
+func (tp *testPomodoro) countMessages(content string) int {
+	n := 0
+	for _, m := range tp.discord.Messages() {
+		if strings.Contains(m.Content, content) {
+			n++
+		}
+	}
+	return n
+}
+
+const (
+	taskReminderMessage  = "でタスクが終わるのん!"
+	breakReminderMessage = "で休憩時間が終わるのんな"
+)
+
+func TestPomodoroReminders(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	tp.call(func() {
+		tp.reminders = Reminders{
+			PhaseKindTask: {10 * time.Minute, time.Minute},
+			// 休憩より長いリマインダーはセットしない
+			PhaseKindBreak: {10 * time.Minute, 5 * time.Minute, 30 * time.Second},
+		}
+	})
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.advance(15*time.Minute - time.Second)
+	if n := tp.countMessages(taskReminderMessage); n != 0 {
+		t.Fatalf("got %d task reminders before 15m", n)
+	}
+	tp.advance(time.Second)
+	if n := tp.countMessages("あと 10m0s " + taskReminderMessage); n != 1 {
+		t.Errorf("got %d reminders for 10m", n)
+	}
+	tp.advance(9 * time.Minute)
+	if n := tp.countMessages("あと 1m0s " + taskReminderMessage); n != 1 {
+		t.Errorf("got %d reminders for 1m", n)
+	}
+
+	tp.advance(time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.advance(5 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusTask)
+	if n := tp.countMessages(breakReminderMessage); n != 1 {
+		t.Errorf("got %d break reminders, want 1", n)
+	}
+	if n := tp.countMessages("あと 30s " + breakReminderMessage); n != 1 {
+		t.Errorf("got %d reminders for 30s", n)
+	}
+	if n := tp.countMessages(taskReminderMessage); n != 2 {
+		t.Errorf("got %d task reminders, want 2", n)
+	}
+}
+
+func TestPomodoroRemindersAreCancelledOnSkip(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	tp.call(func() {
+		tp.reminders = Reminders{PhaseKindTask: {time.Minute}}
+	})
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.advance(10 * time.Minute)
+	if err := tp.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	// スキップしたタスクのリマインダー (24 分) は来ない
+	tp.advance(20 * time.Minute)
+	tp.assertStatus(t, PomodoroStatusTask)
+	if n := tp.countMessages(taskReminderMessage); n != 0 {
+		t.Errorf("got %d task reminders after skip", n)
+	}
+	// 次のタスクは 15 分に始まったので 39 分に来る
+	tp.advance(9 * time.Minute)
+	if n := tp.countMessages(taskReminderMessage); n != 1 {
+		t.Errorf("got %d task reminders in the next task, want 1", n)
+	}
+}
+
+func TestPomodoroRemindersFollowExtend(t *testing.T) {
+	tp := newTestPomodoro(t, "25w 5b")
+	tp.call(func() {
+		tp.reminders = Reminders{PhaseKindTask: {time.Minute}}
+	})
+
+	tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+	tp.advance(20 * time.Minute)
+	if err := tp.Extend(3 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// 延長前の終わりに合わせたリマインダー (24 分) は来ない
+	tp.advance(7*time.Minute - time.Second)
+	if n := tp.countMessages(taskReminderMessage); n != 0 {
+		t.Errorf("got %d task reminders before the extended end", n)
+	}
+	tp.advance(time.Second)
+	if n := tp.countMessages(taskReminderMessage); n != 1 {
+		t.Errorf("got %d task reminders, want 1", n)
+	}
+	tp.advance(time.Minute)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+}
@@ -0,0 +1,271 @@
+package pomodoro
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+func (b *Bot) onGuildCreate(session *discordgo.Session, created *discordgo.GuildCreate) {
+	guild, ok := b.LookupGuildInfo(created.ID)
+	if !ok {
+		return
+	}
+	b.restoreSessions(NewDiscord(session), guild, created.VoiceStates)
+}
+
+// restoreSessions は再起動前に動いていたギルドのセッションを再開する
+// voiceStates は今 VC にいるユーザー (GuildCreate で届く)
+//
+// 保存したメンバーのうち、もう VC にいない人の mute/deafen は解除する
+// VC に誰もいなければセッションを消す
+// 止まっている間に VC に入ってきた人はメンバーに加える
+// VC にいる人の保留していた mute/deafen の解除もここで行う
+func (b *Bot) restoreSessions(discord Discord, guild GuildInfo, voiceStates []*discordgo.VoiceState) {
+	released := map[UserID]VoiceFlags{}
+	for _, vs := range voiceStates {
+		if vs.ChannelID != "" {
+			released[vs.UserID] = b.applyPendingRelease(discord, guild, vs.UserID)
+		}
+	}
+
+	sessions, err := b.store.LoadSessions()
+	if err != nil {
+		log.Printf("Failed to load sessions: %v", err)
+		return
+	}
+
+	usersInVC := map[ChannelID]map[UserID]*discordgo.VoiceState{}
+	for _, vs := range voiceStates {
+		if usersInVC[vs.ChannelID] == nil {
+			usersInVC[vs.ChannelID] = map[UserID]*discordgo.VoiceState{}
+		}
+		usersInVC[vs.ChannelID][vs.UserID] = vs
+	}
+
+	for _, s := range sessions {
+		if s.GuildID != guild.GuildID {
+			continue
+		}
+		if b.isPomodoroRunning(s.VoiceChannelID) {
+			// 再接続しただけで、既に動いている
+			continue
+		}
+
+		present := usersInVC[s.VoiceChannelID]
+		// 保存したメンバーのうち VC に残っている人
+		members := []discordgo.User{}
+		for _, m := range s.Members {
+			if present[m.ID] == nil {
+				log.Printf("Member %s left %s while the bot was offline", m.ID, s.VoiceChannelID)
+				setMemberVoice(discord, b.store, guild.GuildID, m.ID, appliedFlagsOf(m), VoiceFlags{})
+				continue
+			}
+			members = append(members, discordgo.User{ID: m.ID, Username: m.Username})
+		}
+
+		// 残っている人の mute/deafen を外してセッションを消す
+		discard := func() {
+			for _, m := range s.Members {
+				if present[m.ID] != nil {
+					setMemberVoice(discord, b.store, guild.GuildID, m.ID, appliedFlagsOf(m), VoiceFlags{})
+				}
+			}
+			if err := b.store.DeleteSession(s.VoiceChannelID); err != nil {
+				log.Printf("Failed to delete session of %s: %v", s.VoiceChannelID, err)
+			}
+		}
+
+		room, ok := guild.Room(s.VoiceChannelID)
+		if !ok {
+			log.Printf("VC %s is no longer a pomodoro room", s.VoiceChannelID)
+			discard()
+			continue
+		}
+		if len(present) == 0 {
+			discard()
+			continue
+		}
+
+		pomodoro, err := b.getPomodoroWithLock(discord, guild, room)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := pomodoro.Restore(s, members); err != nil {
+			// 壊れたセッションは残さない
+			log.Printf("Failed to restore session of %s: %v", s.VoiceChannelID, err)
+			discard()
+		} else if pomodoro.GetStatus() != PomodoroStatusStop {
+			// 止まっている間に入ってきた人
+			for userID, vs := range present {
+				if pomodoro.HasMember(userID) {
+					continue
+				}
+				user, err := discord.User(userID)
+				if err != nil {
+					log.Printf("Error getting user: %v", err)
+					continue
+				}
+				pomodoro.AddUser(*user, voiceFlagsAfterRelease(vs, released[userID]))
+			}
+		}
+		b.releaseOrUnlockPomodoro(pomodoro, room.VoiceChannelID)
+	}
+}
+
+func keptFlagsOf(m storage.Member) VoiceFlags {
+	return VoiceFlags{Mute: m.KeepMute, Deaf: m.KeepDeaf}
+}
+
+func appliedFlagsOf(m storage.Member) VoiceFlags {
+	return VoiceFlags{Mute: m.Muted, Deaf: m.Deafened}
+}
+
+func (b *Bot) isPomodoroRunning(voiceChannelID ChannelID) bool {
+	pp := b.lookupPomodoroWithLock(voiceChannelID)
+	if pp == nil {
+		return false
+	}
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	return pp.pomo != nil && pp.pomo.GetStatus() != PomodoroStatusStop
+}
+
+// Restore は保存したセッションの続きから再開する
+// members は保存したメンバーのうち今 VC にいる人
+// 止まっている間にフェーズが終わっていた場合は停止する
+func (p *Pomodoro) Restore(s storage.Session, members []discordgo.User) error {
+	var err error
+	p.call(func() {
+		err = p.restore(s, members)
+	})
+	return err
+}
+
+func (p *Pomodoro) restore(s storage.Session, members []discordgo.User) error {
+	if p.status != PomodoroStatusStop {
+		return fmt.Errorf("pomodoro is already running")
+	}
+
+	kind, err := parsePhaseKindName(s.Phase)
+	if err != nil {
+		return err
+	}
+	mode, err := ParsePomodoroMode(s.Mode)
+	if err != nil {
+		return err
+	}
+	schedule, err := ParseSchedule(s.Schedule)
+	if err != nil {
+		return err
+	}
+	if s.PhaseIndex < 0 || s.PhaseIndex >= len(schedule.Phases) {
+		return fmt.Errorf("phase index %d is out of the schedule %q", s.PhaseIndex, s.Schedule)
+	}
+
+	saved := map[UserID]storage.Member{}
+	for _, m := range s.Members {
+		saved[m.ID] = m
+	}
+	for _, user := range members {
+		p.members[user.ID] = user
+		p.kept[user.ID] = keptFlagsOf(saved[user.ID])
+		p.applied[user.ID] = appliedFlagsOf(saved[user.ID])
+		p.joinedActive[user.ID] = saved[user.ID].JoinedActive
+	}
+	p.leftParticipants = append([]storage.Participant(nil), s.LeftParticipants...)
+	// 保存済みのセッションとして扱い、停止したときに消えるようにする
+	savedSession := s
+	savedSession.UpdatedAt = time.Time{}
+	p.savedSession = &savedSession
+
+	p.mode = mode
+	p.schedule = schedule
+	p.cycles = s.Cycles
+	p.phaseIndex = s.PhaseIndex
+	p.round = s.Round
+	p.completedTasks = s.CompletedTasks
+	p.tasksSinceLongBreak = s.TasksSinceLongBreak
+	p.completedBreaks = s.CompletedBreaks
+	p.phaseBeganAt = s.PhaseStartedAt
+	p.pausedAt = s.PausedAt
+	p.pausedFor = s.PausedFor
+	p.phaseStartedAt = s.FocusStartedAt
+	p.elapsedBeforePause = s.FocusedBeforePause
+
+	now := p.clock.Now()
+	timed := !(mode == PomodoroModeFlowtime && kind == PhaseKindTask)
+	localizer := p.localizer()
+	var msg string
+	if timed && !s.Paused && !s.PhaseEndAt.After(now) {
+		// 止まっている間にフェーズが終わっていた
+		log.Printf("Phase of %s ended while the bot was offline", p.voiceChannelID)
+		p.releaseAllMembers()
+
+		messageID := "The pomodoro stopped while the bot was offline."
+		if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID}); err == nil {
+			msg += m
+		} else {
+			msg += messageID
+		}
+		log.Print(msg)
+		p.messageWithAllMembersMention(msg)
+		return nil
+	}
+
+	p.sessionCtx, p.sessionCancel = context.WithCancel(p.ctx)
+	p.status = kind.Status()
+	p.paused = s.Paused
+	p.remaining = s.Remaining
+
+	switch {
+	case s.Paused, !timed:
+		// 再開するまで、または休憩を取るまでタイマーはいらない
+		p.scheduler.reset(p.sessionCtx)
+		p.phaseEndAt = s.PhaseEndAt
+	default:
+		p.armPhaseTimer(s.PhaseEndAt.Sub(now))
+	}
+	log.Printf("Restored pomodoro of %s (phase: %s, paused: %v)", p.voiceChannelID, s.Phase, s.Paused)
+
+	messageID := "Restored the pomodoro after a restart."
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
+	if timed && !s.Paused {
+		msg += "\n"
+		t := p.phaseEndAt.In(p.location)
+		messageID = "The phase will end at DateTime."
+		if m, err := localizer.Localize(&i18n.LocalizeConfig{
+			MessageID: messageID,
+			TemplateData: map[string]interface{}{
+				"DateTime": t.Format("2006/01/02") + " " + t.Format("15:04"),
+			},
+		}); err == nil {
+			msg += m
+		} else {
+			msg += messageID
+		}
+	}
+	log.Print(msg)
+	p.messageWithAllMembersMention(msg)
+
+	if p.focusing() {
+		// 止まっている間に外されたかもしれないので付け直す
+		for userID := range p.members {
+			p.applied[userID] = VoiceFlags{}
+		}
+		p.enforceAllMembers()
+	} else {
+		p.releaseAllMembers()
+	}
+	return nil
+}
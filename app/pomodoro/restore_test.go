@@ -0,0 +1,177 @@
+package pomodoro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+// 10 分経ったところのタスクのセッションを保存して返す
+func savedTaskSession(t *testing.T) storage.Session {
+	t.Helper()
+	tp := newTestPomodoro(t, "25w 5b")
+	tp.AddUser(discordgo.User{ID: "alice", Username: "Alice"}, VoiceFlags{})
+	tp.advance(10 * time.Minute)
+	sessions, err := tp.store.LoadSessions()
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("LoadSessions() = %+v, %v", sessions, err)
+	}
+	return sessions[0]
+}
+
+func TestPomodoroRestore(t *testing.T) {
+	s := savedTaskSession(t)
+
+	tp := newTestPomodoro(t, "50w 10b")
+	tp.advance(10 * time.Minute)
+	if err := tp.Restore(s, []discordgo.User{{ID: "alice", Username: "Alice"}}); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.assertMutedAndDeafened(t, "alice", true)
+	if got := tp.GetSchedule().String(); got != s.Schedule {
+		t.Errorf("schedule = %s, want %s", got, s.Schedule)
+	}
+	messages := tp.assertMessageCount(t, 1)
+	if !mentions(messages[0], "alice") {
+		t.Errorf("restore message does not mention the member: %q", messages[0].Content)
+	}
+
+	// 元の終了時刻にタスクが終わる
+	tp.advance(15*time.Minute - time.Second)
+	tp.assertStatus(t, PomodoroStatusTask)
+	tp.advance(time.Second)
+	tp.assertStatus(t, PomodoroStatusBreakTime)
+	tp.assertMutedAndDeafened(t, "alice", false)
+
+	phases, _ := tp.store.LoadPhases("", time.Time{})
+	if len(phases) != 1 || phases[0].Duration != 25*time.Minute {
+		t.Errorf("recorded phases = %+v", phases)
+	}
+}
+
+func TestPomodoroRestoreAfterPhaseEnded(t *testing.T) {
+	s := savedTaskSession(t)
+
+	tp := newTestPomodoro(t, "25w 5b")
+	tp.discord.MuteMember(testGuildID, "alice", true)
+	tp.discord.DeafenMember(testGuildID, "alice", true)
+	tp.store.SaveSession(s)
+	tp.advance(time.Hour)
+	if err := tp.Restore(s, []discordgo.User{{ID: "alice"}}); err != nil {
+		t.Fatal(err)
+	}
+	tp.assertStatus(t, PomodoroStatusStop)
+	tp.assertMutedAndDeafened(t, "alice", false)
+	tp.assertMessageCount(t, 1)
+	if sessions, _ := tp.store.LoadSessions(); len(sessions) != 0 {
+		t.Errorf("session was not deleted: %+v", sessions)
+	}
+}
+
+func TestRestoreSessions(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+	discord := newFakeDiscord()
+	for _, userID := range []UserID{"alice", "bob", "carol"} {
+		discord.MuteMember(testGuildID, userID, true)
+		discord.DeafenMember(testGuildID, userID, true)
+	}
+
+	now := time.Now()
+	for _, s := range []storage.Session{
+		{
+			GuildID:        testGuildID,
+			VoiceChannelID: testVoiceChannelID,
+			Phase:          storage.PhaseTask,
+			Mode:           PomodoroModePomodoro.String(),
+			Schedule:       "25w 5b",
+			PhaseStartedAt: now.Add(-10 * time.Minute),
+			PhaseEndAt:     now.Add(15 * time.Minute),
+			Members:        []storage.Member{{ID: "alice", Muted: true, Deafened: true}, {ID: "bob", Muted: true, Deafened: true}},
+		},
+		{
+			GuildID:        testGuildID,
+			VoiceChannelID: "vc2",
+			Phase:          storage.PhaseTask,
+			Mode:           PomodoroModePomodoro.String(),
+			Schedule:       "25w 5b",
+			PhaseEndAt:     now.Add(15 * time.Minute),
+			Members:        []storage.Member{{ID: "carol", Muted: true, Deafened: true}},
+		},
+	} {
+		if err := store.SaveSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// alice だけが VC に残っている
+	b.restoreSessions(discord, testGuildInfo(), []*discordgo.VoiceState{
+		{GuildID: testGuildID, ChannelID: testVoiceChannelID, UserID: "alice"},
+		{GuildID: testGuildID, ChannelID: "lobby", UserID: "dave"},
+	})
+	t.Cleanup(func() {
+		b.handleVoiceStateUpdate(discord, voiceStateUpdate(testGuildID, "alice", testVoiceChannelID, ""))
+	})
+
+	pp := b.lookupPomodoroWithLock(testVoiceChannelID)
+	if pp == nil || pp.pomo == nil {
+		t.Fatal("pomodoro was not restored")
+	}
+	if pp.pomo.GetStatus() != PomodoroStatusTask || !pp.pomo.HasMember("alice") || pp.pomo.HasMember("bob") {
+		t.Errorf("restored pomodoro: status = %v, members = %d", pp.pomo.GetStatus(), pp.pomo.MemberCount())
+	}
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); !mute || !deaf {
+		t.Errorf("alice: mute = %v, deaf = %v", mute, deaf)
+	}
+	// 抜けた人と、誰もいなくなったルームの人は解除する
+	for _, userID := range []UserID{"bob", "carol"} {
+		if mute, deaf := discord.MutedAndDeafened(testGuildID, userID); mute || deaf {
+			t.Errorf("%s: mute = %v, deaf = %v", userID, mute, deaf)
+		}
+	}
+
+	sessions, err := store.LoadSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].VoiceChannelID != testVoiceChannelID {
+		t.Errorf("sessions after restore = %+v", sessions)
+	}
+}
+
+// 止まっている間に VC に入っていた人の保留していた解除を行う
+func TestRestoreSessionsAppliesPendingReleases(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+	discord := newFakeDiscord()
+	discord.MuteMember(testGuildID, "alice", true)
+	discord.DeafenMember(testGuildID, "alice", true)
+	// 後から mute だけ解除できなかった分も合わせて解除する
+	for _, r := range []storage.PendingRelease{
+		{GuildID: testGuildID, UserID: "alice", KeepMute: true},
+		{GuildID: testGuildID, UserID: "alice", KeepDeaf: true},
+		{GuildID: testGuildID, UserID: "bob"},
+	} {
+		if err := store.SavePendingRelease(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.restoreSessions(discord, testGuildInfo(), []*discordgo.VoiceState{
+		{GuildID: testGuildID, ChannelID: "lobby", UserID: "alice", Mute: true, Deaf: true},
+	})
+
+	if mute, deaf := discord.MutedAndDeafened(testGuildID, "alice"); mute || deaf {
+		t.Errorf("alice: mute = %v, deaf = %v", mute, deaf)
+	}
+	if dms := discord.DirectMessages("alice"); len(dms) != 1 {
+		t.Errorf("got %d direct messages, want 1", len(dms))
+	}
+	// VC にいない人の解除は残す
+	if releases, _ := store.LoadPendingReleases(testGuildID); len(releases) != 1 || releases[0].UserID != "bob" {
+		t.Errorf("pending releases = %+v", releases)
+	}
+}
@@ -0,0 +1,209 @@
+package pomodoro
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PhaseKind int
+
+const (
+	PhaseKindTask PhaseKind = iota
+	PhaseKindBreak
+	PhaseKindLongBreak
+)
+
+// DSL で使う一文字の記号
+var phaseKindSymbols = map[PhaseKind]string{
+	PhaseKindTask:      "w",
+	PhaseKindBreak:     "b",
+	PhaseKindLongBreak: "l",
+}
+
+func (k PhaseKind) Status() PomodoroStatus {
+	switch k {
+	case PhaseKindBreak:
+		return PomodoroStatusBreakTime
+	case PhaseKindLongBreak:
+		return PomodoroStatusLongBreakTime
+	default:
+		return PomodoroStatusTask
+	}
+}
+
+type Phase struct {
+	Kind     PhaseKind
+	Duration time.Duration
+}
+
+// Schedule は Pomodoro が順番に進めるフェーズの並び
+type Schedule struct {
+	Phases []Phase
+	// Phases を何周したら終了するか (0 なら終了しない)
+	Repeat int
+}
+
+// ParseSchedule parses a schedule such as "25w 5b 25w 5b 25w 15l".
+//
+// Each phase is written as <duration><kind> where kind is one of
+// w (work), b (break) or l (long break) and duration is either a number of
+// minutes ("25w") or a Go duration ("1h30mw", "90sb").
+// The phases repeat forever unless the last token is "stop" (run once) or
+// "xN" (run N times). "*" may be written to repeat forever explicitly.
+func ParseSchedule(s string) (Schedule, error) {
+	tokens := strings.Fields(s)
+	if len(tokens) == 0 {
+		return Schedule{}, fmt.Errorf("schedule is empty")
+	}
+
+	schedule := Schedule{}
+
+	// 末尾の繰り返し指定
+	last := tokens[len(tokens)-1]
+	switch {
+	case last == "*":
+		tokens = tokens[:len(tokens)-1]
+	case last == "stop":
+		schedule.Repeat = 1
+		tokens = tokens[:len(tokens)-1]
+	case strings.HasPrefix(last, "x"):
+		n, err := strconv.Atoi(last[1:])
+		if err != nil || n < 1 {
+			return Schedule{}, fmt.Errorf("invalid repeat %q: must be x followed by a positive number (e.g. x4)", last)
+		}
+		schedule.Repeat = n
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	hasTask := false
+	for i, token := range tokens {
+		if token == "*" || token == "stop" || isRepeatToken(token) {
+			return Schedule{}, fmt.Errorf("phase %d (%q): repeat must be the last token", i+1, token)
+		}
+		phase, err := parsePhase(token)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("phase %d (%q): %w", i+1, token, err)
+		}
+		if phase.Kind == PhaseKindTask {
+			hasTask = true
+		}
+		schedule.Phases = append(schedule.Phases, phase)
+	}
+
+	if len(schedule.Phases) == 0 {
+		return Schedule{}, fmt.Errorf("schedule has no phases")
+	}
+	if !hasTask {
+		return Schedule{}, fmt.Errorf("schedule has no work phase (w)")
+	}
+
+	return schedule, nil
+}
+
+func isRepeatToken(token string) bool {
+	if !strings.HasPrefix(token, "x") {
+		return false
+	}
+	_, err := strconv.Atoi(token[1:])
+	return err == nil
+}
+
+func parsePhase(token string) (Phase, error) {
+	if len(token) < 2 {
+		return Phase{}, fmt.Errorf("must be <duration><kind> (e.g. 25w)")
+	}
+
+	symbol := token[len(token)-1:]
+	kind, ok := PhaseKind(-1), false
+	for k, sym := range phaseKindSymbols {
+		if sym == symbol {
+			kind, ok = k, true
+			break
+		}
+	}
+	if !ok {
+		return Phase{}, fmt.Errorf("unknown phase kind %q (use w, b or l)", symbol)
+	}
+
+	durationPart := token[:len(token)-1]
+	var d time.Duration
+	if minutes, err := strconv.Atoi(durationPart); err == nil {
+		d = time.Duration(minutes) * time.Minute
+	} else if d, err = time.ParseDuration(durationPart); err != nil {
+		return Phase{}, fmt.Errorf("invalid duration %q", durationPart)
+	}
+	if d <= 0 {
+		return Phase{}, fmt.Errorf("duration must be positive")
+	}
+
+	return Phase{Kind: kind, Duration: d}, nil
+}
+
+func (s Schedule) String() string {
+	tokens := []string{}
+	for _, phase := range s.Phases {
+		var d string
+		if phase.Duration%time.Minute == 0 {
+			d = strconv.Itoa(int(phase.Duration / time.Minute))
+		} else {
+			d = phase.Duration.String()
+		}
+		tokens = append(tokens, d+phaseKindSymbols[phase.Kind])
+	}
+	switch s.Repeat {
+	case 0:
+	case 1:
+		tokens = append(tokens, "stop")
+	default:
+		tokens = append(tokens, fmt.Sprintf("x%d", s.Repeat))
+	}
+	return strings.Join(tokens, " ")
+}
+
+// DefaultSchedule は task と break を交互に繰り返し、
+// interval 回ごとに長い休憩を取るスケジュールを作る
+func DefaultSchedule(
+	taskDuration time.Duration,
+	breakDuration time.Duration,
+	longBreakDuration time.Duration,
+	longBreakInterval int,
+) Schedule {
+	schedule := Schedule{}
+	for i := 1; i <= longBreakInterval; i++ {
+		schedule.Phases = append(schedule.Phases, Phase{Kind: PhaseKindTask, Duration: taskDuration})
+		if i < longBreakInterval {
+			schedule.Phases = append(schedule.Phases, Phase{Kind: PhaseKindBreak, Duration: breakDuration})
+		} else {
+			schedule.Phases = append(schedule.Phases, Phase{Kind: PhaseKindLongBreak, Duration: longBreakDuration})
+		}
+	}
+	return schedule
+}
+
+// index のフェーズの後、次の長い休憩までに残っているタスクの数を返す
+// 長い休憩が来なければ -1 を返す
+func (s Schedule) tasksUntilLongBreak(index int) int {
+	if s.Phases[index].Kind == PhaseKindLongBreak {
+		return 0
+	}
+
+	n := len(s.Phases) - index - 1
+	if s.Repeat != 1 {
+		// 繰り返す場合は一周分先まで見る
+		n = len(s.Phases) - 1
+	}
+
+	count := 0
+	for i := 1; i <= n; i++ {
+		phase := s.Phases[(index+i)%len(s.Phases)]
+		switch phase.Kind {
+		case PhaseKindTask:
+			count++
+		case PhaseKindLongBreak:
+			return count
+		}
+	}
+	return -1
+}
@@ -0,0 +1,144 @@
+package pomodoro
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want Schedule
+	}{
+		{"25w 5b", Schedule{Phases: []Phase{
+			{PhaseKindTask, 25 * time.Minute},
+			{PhaseKindBreak, 5 * time.Minute},
+		}}},
+		{"25w 5b 25w 15l *", Schedule{Phases: []Phase{
+			{PhaseKindTask, 25 * time.Minute},
+			{PhaseKindBreak, 5 * time.Minute},
+			{PhaseKindTask, 25 * time.Minute},
+			{PhaseKindLongBreak, 15 * time.Minute},
+		}}},
+		{"1h30mw 90sb stop", Schedule{Phases: []Phase{
+			{PhaseKindTask, 90 * time.Minute},
+			{PhaseKindBreak, 90 * time.Second},
+		}, Repeat: 1}},
+		{"  50w   10b x3 ", Schedule{Phases: []Phase{
+			{PhaseKindTask, 50 * time.Minute},
+			{PhaseKindBreak, 10 * time.Minute},
+		}, Repeat: 3}},
+	} {
+		got, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) = %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSchedule(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		err  string
+	}{
+		{"", "schedule is empty"},
+		{"*", "schedule has no phases"},
+		{"5b 15l", "schedule has no work phase (w)"},
+		// 繰り返しは最後にしか書けない
+		{"25w stop 5b", `phase 2 ("stop"): repeat must be the last token`},
+		{"25w * 5b", `phase 2 ("*"): repeat must be the last token`},
+		{"x2 25w 5b", `phase 1 ("x2"): repeat must be the last token`},
+		{"25w 5b x0", `invalid repeat "x0": must be x followed by a positive number (e.g. x4)`},
+		{"25w 5b xx", `invalid repeat "xx": must be x followed by a positive number (e.g. x4)`},
+		{"25w 5q", `phase 2 ("5q"): unknown phase kind "q" (use w, b or l)`},
+		{"w", `phase 1 ("w"): must be <duration><kind> (e.g. 25w)`},
+		{"25w fiveb", `phase 2 ("fiveb"): invalid duration "five"`},
+		{"0w", `phase 1 ("0w"): duration must be positive`},
+		{"25w -5b", `phase 2 ("-5b"): duration must be positive`},
+		{"25w -1m30sb", `phase 2 ("-1m30sb"): duration must be positive`},
+	} {
+		_, err := ParseSchedule(tt.spec)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("ParseSchedule(%q) = %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestScheduleStringRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want string
+	}{
+		{"25w 5b", "25w 5b"},
+		{"25w 5b 25w 15l *", "25w 5b 25w 15l"},
+		{"1h30mw 90sb stop", "90w 1m30sb stop"},
+		{"50w 10b x3", "50w 10b x3"},
+	} {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.String(); got != tt.want {
+			t.Errorf("ParseSchedule(%q).String() = %q, want %q", tt.spec, got, tt.want)
+		}
+		again, err := ParseSchedule(s.String())
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) = %v", s.String(), err)
+			continue
+		}
+		if !reflect.DeepEqual(again, s) {
+			t.Errorf("round trip of %q = %+v, want %+v", tt.spec, again, s)
+		}
+	}
+
+	if got, want := DefaultSchedule(25*time.Minute, 5*time.Minute, 15*time.Minute, 2).String(), "25w 5b 25w 15l"; got != want {
+		t.Errorf("DefaultSchedule().String() = %q, want %q", got, want)
+	}
+}
+
+func TestTasksUntilLongBreak(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		// フェーズごとの期待値
+		want []int
+	}{
+		{"25w 5b 25w 15l", []int{1, 1, 0, 0}},
+		// 繰り返すので次の周の長い休憩まで数える
+		{"25w 15l 25w 5b", []int{0, 0, 1, 1}},
+		// 一周で終わるので、過ぎた長い休憩は来ない
+		{"25w 15l 25w 5b stop", []int{0, 0, -1, -1}},
+		{"25w 5b", []int{-1, -1}},
+	} {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []int{}
+		for i := range s.Phases {
+			got = append(got, s.tasksUntilLongBreak(i))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: tasksUntilLongBreak = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+// 末尾の繰り返し指定
+func TestParseScheduleRepeatSuffixes(t *testing.T) {
+	for suffix, repeat := range map[string]int{"": 0, "*": 0, "stop": 1, "x1": 1, "x10": 10} {
+		s, err := ParseSchedule(strings.TrimSpace("25w 5b " + suffix))
+		if err != nil {
+			t.Errorf("%q: %v", suffix, err)
+			continue
+		}
+		if s.Repeat != repeat {
+			t.Errorf("%q: Repeat = %d, want %d", suffix, s.Repeat, repeat)
+		}
+	}
+}
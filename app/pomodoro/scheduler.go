@@ -0,0 +1,92 @@
+package pomodoro
+
+import (
+	"context"
+	"time"
+
+	"github.com/pollenjp/pomodoro-bot/app/clock"
+)
+
+type timerEventKind int
+
+const (
+	timerEventPhaseEnd timerEventKind = iota
+	timerEventReminder
+)
+
+type timerEvent struct {
+	// phaseScheduler.generation when the timer was armed
+	generation uint64
+	kind       timerEventKind
+	// reminder only
+	phaseKind PhaseKind
+	offset    time.Duration
+	// 受け取った側が処理し終えたら close する
+	handled chan struct{}
+}
+
+// phaseScheduler は現在のフェーズで動いているタイマーをすべて管理する
+// Pomodoro の goroutine からのみ触る
+//
+// タイマーが発火すると events にイベントが送られる
+// タイマーの関数は次のタイマーが設定されるまで (イベントの処理が終わるまで) 戻らないので、
+// clock.Fake の Advance でいくつものフェーズをまとめて進められる
+// reset / cancelAll でフェーズの context をキャンセルするので、
+// 止めたタイマーのイベントが後から届くことはない
+// (キャンセルと同時に発火したイベントは generation で捨てる)
+type phaseScheduler struct {
+	clock      clock.Clock
+	events     chan timerEvent
+	ctx        context.Context
+	cancel     context.CancelFunc
+	generation uint64
+	timers     []clock.Timer
+}
+
+func newPhaseScheduler(c clock.Clock) *phaseScheduler {
+	return &phaseScheduler{
+		clock:  c,
+		events: make(chan timerEvent),
+	}
+}
+
+// 現在のフェーズのタイマーをすべて止めて、parent に紐づく新しいフェーズを始める
+func (s *phaseScheduler) reset(parent context.Context) {
+	s.cancelAll()
+	s.generation++
+	s.ctx, s.cancel = context.WithCancel(parent)
+}
+
+func (s *phaseScheduler) cancelAll() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	for _, t := range s.timers {
+		t.Stop()
+	}
+	s.timers = nil
+}
+
+// d 後に ev を events に送る
+func (s *phaseScheduler) after(d time.Duration, ev timerEvent) {
+	if s.ctx == nil || s.ctx.Err() != nil {
+		return
+	}
+	ev.generation = s.generation
+	ctx := s.ctx
+	events := s.events
+	s.timers = append(s.timers, s.clock.AfterFunc(d, func() {
+		ev := ev
+		ev.handled = make(chan struct{})
+		select {
+		case events <- ev:
+			<-ev.handled
+		case <-ctx.Done():
+		}
+	}))
+}
+
+// 止めたフェーズのイベントであれば false を返す
+func (s *phaseScheduler) isCurrent(ev timerEvent) bool {
+	return ev.generation == s.generation && s.ctx != nil && s.ctx.Err() == nil
+}
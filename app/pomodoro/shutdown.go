@@ -0,0 +1,83 @@
+package pomodoro
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// Shutdown は bot を終了する前に呼ぶ
+// タイマーを止めて全員の mute/deafen を解除するが、
+// 再起動したときに再開できるように保存したセッションは残す
+func (p *Pomodoro) Shutdown() {
+	p.call(p.shutdown)
+}
+
+func (p *Pomodoro) shutdown() {
+	if p.status == PomodoroStatusStop {
+		return
+	}
+	p.releaseAllMembers()
+	// 外した mute/deafen は再開するときに付け直す
+	p.persistSession()
+
+	p.stopSession()
+	// persistSession でセッションを消さないようにする
+	p.savedSession = nil
+	log.Printf("Interrupted pomodoro of %s for shutdown", p.voiceChannelID)
+
+	localizer := p.localizer()
+	var msg string
+	messageID := "The bot is restarting."
+	if m, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID}); err == nil {
+		msg += m
+	} else {
+		msg += messageID
+	}
+	log.Print(msg)
+	p.messageWithAllMembersMention(msg)
+}
+
+// shutdownPomodoros は動いているすべての Pomodoro を止めて破棄する
+// 呼んだ後は新しい Pomodoro を作らない
+// ctx の期限までに終わらなければ、残りを待たずにエラーを返す
+func (b *Bot) shutdownPomodoros(ctx context.Context) error {
+	b.pomodoroMapLock.Lock()
+	b.shuttingDown = true
+	pomodoros := []*PomodoroWithLock{}
+	for _, pp := range b.pomodoroMap {
+		pomodoros = append(pomodoros, pp)
+	}
+	b.pomodoroMapLock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pp := range pomodoros {
+		wg.Add(1)
+		go func(pp *PomodoroWithLock) {
+			defer wg.Done()
+			pp.lock.Lock()
+			defer pp.lock.Unlock()
+			if pp.pomo == nil {
+				return
+			}
+			pp.pomo.Shutdown()
+			pp.pomo.Close()
+			pp.pomo = nil
+		}(pp)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("some pomodoros did not stop in time: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,118 @@
+package pomodoro
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+// UserStats は集中した記録の集計
+type UserStats struct {
+	// 最初から最後までいたタスクの数
+	Completed int
+	// タスクに参加していた時間の合計 (途中で抜けたものも含む)
+	Focused time.Duration
+}
+
+func (s *UserStats) add(p storage.Participant) {
+	if p.Completed {
+		s.Completed++
+	}
+	s.Focused += p.Focused
+}
+
+// フェーズに参加したメンバー
+// Participants がない古い記録は、終わったときにいたメンバーが最初からいたものとする
+func participantsOf(r storage.PhaseRecord) []storage.Participant {
+	if len(r.Participants) > 0 {
+		return r.Participants
+	}
+	participants := []storage.Participant{}
+	for _, userID := range r.Members {
+		participants = append(participants, storage.Participant{
+			UserID:    userID,
+			Focused:   r.Duration,
+			Completed: !r.Skipped && !r.Stopped,
+		})
+	}
+	return participants
+}
+
+// 集計に使うタスクの参加記録を順に f に渡す
+func eachTaskParticipant(records []storage.PhaseRecord, f func(r storage.PhaseRecord, p storage.Participant)) {
+	for _, r := range records {
+		if r.Phase != storage.PhaseTask {
+			continue
+		}
+		for _, p := range participantsOf(r) {
+			f(r, p)
+		}
+	}
+}
+
+// その日の 0 時 (now のタイムゾーン)
+func startOfDay(now time.Time) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+}
+
+// その週の月曜日の 0 時
+func startOfWeek(now time.Time) time.Time {
+	day := startOfDay(now)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+type userStatsSummary struct {
+	Today   UserStats
+	Week    UserStats
+	AllTime UserStats
+}
+
+func summarizeUserStats(records []storage.PhaseRecord, userID UserID, now time.Time) userStatsSummary {
+	today := startOfDay(now)
+	week := startOfWeek(now)
+
+	var summary userStatsSummary
+	eachTaskParticipant(records, func(r storage.PhaseRecord, p storage.Participant) {
+		if p.UserID != userID {
+			return
+		}
+		summary.AllTime.add(p)
+		if !r.EndedAt.Before(week) {
+			summary.Week.add(p)
+		}
+		if !r.EndedAt.Before(today) {
+			summary.Today.add(p)
+		}
+	})
+	return summary
+}
+
+func formatUserStats(s UserStats) string {
+	return fmt.Sprintf("%d pomodoros\n%d min", s.Completed, int(s.Focused.Minutes()))
+}
+
+// user の統計を embed で返す
+// 失敗したときはメッセージを返す
+func (b *Bot) statsCommand(guild GuildInfo, user *discordgo.User) (*discordgo.MessageEmbed, string) {
+	records, err := b.store.LoadPhases(guild.GuildID, time.Time{})
+	if err != nil {
+		log.Printf("Failed to load phases of guild (%s): %v", guild.GuildID, err)
+		return nil, "Failed to load the history."
+	}
+	summary := summarizeUserStats(records, user.ID, time.Now().In(guild.location()))
+
+	return &discordgo.MessageEmbed{
+		Title:       "Pomodoro stats",
+		Description: user.Mention(),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Today", Value: formatUserStats(summary.Today), Inline: true},
+			{Name: "This week", Value: formatUserStats(summary.Week), Inline: true},
+			{Name: "All time", Value: formatUserStats(summary.AllTime), Inline: true},
+		},
+	}, ""
+}
@@ -0,0 +1,43 @@
+package pomodoro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+func TestSummarizeUserStats(t *testing.T) {
+	// 2024-05-15 は水曜日
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	task := func(endedAt time.Time, participants ...storage.Participant) storage.PhaseRecord {
+		return storage.PhaseRecord{Phase: storage.PhaseTask, EndedAt: endedAt, Duration: 25 * time.Minute, Participants: participants}
+	}
+	full := storage.Participant{UserID: "alice", Focused: 25 * time.Minute, Completed: true}
+	left := storage.Participant{UserID: "alice", Focused: 10 * time.Minute, Left: true}
+
+	records := []storage.PhaseRecord{
+		// 先週
+		task(time.Date(2024, 5, 12, 23, 0, 0, 0, time.UTC), full),
+		// 今週の月曜日
+		task(time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), full, storage.Participant{UserID: "bob", Focused: 25 * time.Minute, Completed: true}),
+		// 今日
+		task(time.Date(2024, 5, 15, 9, 0, 0, 0, time.UTC), left),
+		task(time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC), full),
+		// 休憩は数えない
+		{Phase: storage.PhaseBreak, EndedAt: time.Date(2024, 5, 15, 10, 5, 0, 0, time.UTC), Duration: 5 * time.Minute, Participants: []storage.Participant{full}},
+		// Participants がない古い記録
+		{Phase: storage.PhaseTask, EndedAt: time.Date(2024, 5, 15, 11, 0, 0, 0, time.UTC), Duration: 25 * time.Minute, Members: []string{"alice"}},
+		{Phase: storage.PhaseTask, EndedAt: time.Date(2024, 5, 15, 11, 30, 0, 0, time.UTC), Duration: 5 * time.Minute, Skipped: true, Members: []string{"alice"}},
+	}
+
+	got := summarizeUserStats(records, "alice", now)
+	want := userStatsSummary{
+		Today:   UserStats{Completed: 2, Focused: 65 * time.Minute},
+		Week:    UserStats{Completed: 3, Focused: 90 * time.Minute},
+		AllTime: UserStats{Completed: 4, Focused: 115 * time.Minute},
+	}
+	if got != want {
+		t.Errorf("summarizeUserStats() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+package pomodoro
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+var phaseKindNames = map[PhaseKind]string{
+	PhaseKindTask:      storage.PhaseTask,
+	PhaseKindBreak:     storage.PhaseBreak,
+	PhaseKindLongBreak: storage.PhaseLongBreak,
+}
+
+func parsePhaseKindName(name string) (PhaseKind, error) {
+	for kind, n := range phaseKindNames {
+		if n == name {
+			return kind, nil
+		}
+	}
+	return PhaseKindTask, fmt.Errorf("unknown phase kind: %s", name)
+}
+
+func (c GuildConfig) toStorage() storage.GuildConfig {
+	reminders := map[string][]time.Duration{}
+	for kind, offsets := range c.Reminders {
+		reminders[phaseKindNames[kind]] = append([]time.Duration{}, offsets...)
+	}
+	return storage.GuildConfig{
+		TaskDuration:       c.TaskDuration,
+		BreakDuration:      c.BreakDuration,
+		LongBreakDuration:  c.LongBreakDuration,
+		LongBreakInterval:  c.LongBreakInterval,
+		Cycles:             c.Cycles,
+		Mode:               c.Mode.String(),
+		Enforcement:        c.Enforcement.String(),
+		FlowtimeBreakRatio: c.FlowtimeBreakRatio,
+		Schedule:           c.ScheduleSpec,
+		Reminders:          reminders,
+		StreakGoal:         c.StreakGoal,
+	}
+}
+
+func guildConfigFromStorage(s storage.GuildConfig) (GuildConfig, error) {
+	mode, err := ParsePomodoroMode(s.Mode)
+	if err != nil {
+		return GuildConfig{}, err
+	}
+	// 保存されていなければ mute+deafen
+	enforcement := EnforcementMuteAndDeafen
+	if s.Enforcement != "" {
+		if enforcement, err = ParseEnforcement(s.Enforcement); err != nil {
+			return GuildConfig{}, err
+		}
+	}
+	// 保存されていなければデフォルト
+	streakGoal := PomodoroStreakGoal
+	if s.StreakGoal != 0 {
+		streakGoal = s.StreakGoal
+	}
+	reminders := Reminders{}
+	for name, offsets := range s.Reminders {
+		kind, err := parsePhaseKindName(name)
+		if err != nil {
+			return GuildConfig{}, err
+		}
+		reminders[kind] = append([]time.Duration{}, offsets...)
+	}
+	c := GuildConfig{
+		TaskDuration:       s.TaskDuration,
+		BreakDuration:      s.BreakDuration,
+		Reminders:          reminders,
+		LongBreakDuration:  s.LongBreakDuration,
+		LongBreakInterval:  s.LongBreakInterval,
+		Cycles:             s.Cycles,
+		Mode:               mode,
+		Enforcement:        enforcement,
+		FlowtimeBreakRatio: s.FlowtimeBreakRatio,
+		ScheduleSpec:       s.Schedule,
+		StreakGoal:         streakGoal,
+	}
+	if err := c.Validate(); err != nil {
+		return GuildConfig{}, err
+	}
+	return c, nil
+}
+
+// 動いているセッションの状態
+func (p *Pomodoro) sessionRecord() storage.Session {
+	members := []storage.Member{}
+	for _, user := range p.members {
+		kept := p.kept[user.ID]
+		applied := p.applied[user.ID]
+		members = append(members, storage.Member{
+			ID:           user.ID,
+			Username:     user.Username,
+			KeepMute:     kept.Mute,
+			KeepDeaf:     kept.Deaf,
+			Muted:        applied.Mute,
+			Deafened:     applied.Deaf,
+			JoinedActive: p.joinedActive[user.ID],
+		})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+
+	return storage.Session{
+		GuildID:             p.guildID,
+		VoiceChannelID:      p.voiceChannelID,
+		TextChannelID:       p.textChannelID,
+		Phase:               phaseKindNames[p.status.phaseKind()],
+		Mode:                p.mode.String(),
+		Schedule:            p.schedule.String(),
+		Cycles:              p.cycles,
+		PhaseIndex:          p.phaseIndex,
+		Round:               p.round,
+		CompletedTasks:      p.completedTasks,
+		TasksSinceLongBreak: p.tasksSinceLongBreak,
+		CompletedBreaks:     p.completedBreaks,
+		PhaseStartedAt:      p.phaseBeganAt,
+		PhaseEndAt:          p.phaseEndAt,
+		Paused:              p.paused,
+		PausedAt:            p.pausedAt,
+		PausedFor:           p.pausedFor,
+		Remaining:           p.remaining,
+		FocusStartedAt:      p.phaseStartedAt,
+		FocusedBeforePause:  p.elapsedBeforePause,
+		Members:             members,
+		LeftParticipants:    append([]storage.Participant(nil), p.leftParticipants...),
+	}
+}
+
+// 状態が変わっていればセッションを保存する
+// 停止していれば保存したセッションを消す
+// run goroutine でリクエストやタイマーを処理するたびに呼ばれる
+func (p *Pomodoro) persistSession() {
+	if p.status == PomodoroStatusStop {
+		if p.savedSession == nil {
+			return
+		}
+		if err := p.store.DeleteSession(p.voiceChannelID); err != nil {
+			log.Printf("Failed to delete session of %s: %v", p.voiceChannelID, err)
+			return
+		}
+		p.savedSession = nil
+		return
+	}
+
+	record := p.sessionRecord()
+	if p.savedSession != nil && reflect.DeepEqual(*p.savedSession, record) {
+		return
+	}
+	saved := record
+	record.UpdatedAt = p.clock.Now()
+	if err := p.store.SaveSession(record); err != nil {
+		log.Printf("Failed to save session of %s: %v", p.voiceChannelID, err)
+		return
+	}
+	p.savedSession = &saved
+}
+
+// フェーズの終わり方
+type phaseOutcome int
+
+const (
+	// 時間になった、または flowtime で休憩を取った
+	phaseCompleted phaseOutcome = iota
+	phaseSkipped
+	// 全員抜けたなどで途中で停止した
+	phaseStopped
+)
+
+// 現在のフェーズを履歴に残す
+// endPhase や stopSession の前に呼ぶ
+func (p *Pomodoro) recordPhase(outcome phaseOutcome) {
+	if p.status == PomodoroStatusStop {
+		return
+	}
+	members := []string{}
+	for userID := range p.members {
+		members = append(members, userID)
+	}
+	sort.Strings(members)
+
+	active := p.phaseActive()
+	participants := append([]storage.Participant{}, p.leftParticipants...)
+	for _, userID := range members {
+		joined := p.joinedActive[userID]
+		participants = append(participants, storage.Participant{
+			UserID:    userID,
+			Focused:   active - joined,
+			Completed: outcome == phaseCompleted && joined == 0,
+		})
+	}
+
+	now := p.clock.Now()
+	if err := p.store.AppendPhase(storage.PhaseRecord{
+		GuildID:        p.guildID,
+		VoiceChannelID: p.voiceChannelID,
+		Phase:          phaseKindNames[p.status.phaseKind()],
+		StartedAt:      p.phaseBeganAt,
+		EndedAt:        now,
+		Duration:       active,
+		Skipped:        outcome == phaseSkipped,
+		Stopped:        outcome == phaseStopped,
+		Members:        members,
+		Participants:   participants,
+	}); err != nil {
+		log.Printf("Failed to record phase of %s: %v", p.voiceChannelID, err)
+	}
+}
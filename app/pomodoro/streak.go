@@ -0,0 +1,218 @@
+package pomodoro
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+const (
+	// この時刻 (ユーザーのタイムゾーン) を過ぎても今日の目標に届いていなければ DM を送る
+	StreakReminderHour     = 20
+	streakReminderInterval = 10 * time.Minute
+
+	streakDateLayout = "2006-01-02"
+
+	// 連続記録を数えるときに最初に読み込む日数
+	// その端まで記録が続いていれば、倍にして読み直す
+	streakWindowDays = 32
+)
+
+// 連続記録を数えるタイムゾーン
+// ユーザーが設定していなければギルドのタイムゾーン
+func userLocation(guildLocation *time.Location, prefs storage.UserPrefs) *time.Location {
+	if prefs.TimeZone == "" {
+		return guildLocation
+	}
+	loc, err := time.LoadLocation(prefs.TimeZone)
+	if err != nil {
+		log.Printf("Invalid time zone of %s: %v", prefs.UserID, err)
+		return guildLocation
+	}
+	return loc
+}
+
+// 日ごとに完了したポモドーロの数 (キーは loc での日付)
+func dailyCompleted(records []storage.PhaseRecord, userID UserID, loc *time.Location) map[string]int {
+	daily := map[string]int{}
+	eachTaskParticipant(records, func(r storage.PhaseRecord, p storage.Participant) {
+		if p.UserID == userID && p.Completed {
+			daily[r.EndedAt.In(loc).Format(streakDateLayout)]++
+		}
+	})
+	return daily
+}
+
+// goal 回以上ポモドーロを終えた日が now の日まで何日続いているか (now のタイムゾーン)
+// 今日まだ goal に届いていなければ昨日までを数え、doneToday は false になる
+func currentStreak(records []storage.PhaseRecord, userID UserID, goal int, now time.Time) (streak int, doneToday bool) {
+	daily := dailyCompleted(records, userID, now.Location())
+	day := startOfDay(now)
+	doneToday = daily[day.Format(streakDateLayout)] >= goal
+	if !doneToday {
+		day = day.AddDate(0, 0, -1)
+	}
+	for daily[day.Format(streakDateLayout)] >= goal {
+		streak++
+		day = day.AddDate(0, 0, -1)
+	}
+	return streak, doneToday
+}
+
+// locs のユーザーそれぞれのタイムゾーンで now までの連続記録を数える (記録がないユーザーは含まない)
+// 全履歴を読まないように、最近の streakWindowDays 日分から読み込む
+func loadStreaks(store storage.Store, guildID GuildID, goal int, now time.Time, locs map[UserID]*time.Location) (map[UserID]int, error) {
+	streaks := map[UserID]int{}
+	pending := locs
+	for days := streakWindowDays; len(pending) > 0; days *= 2 {
+		// タイムゾーンの違いの分、1 日余分に読む
+		records, err := store.LoadPhases(guildID, now.AddDate(0, 0, -days-1))
+		if err != nil {
+			return streaks, err
+		}
+		next := map[UserID]*time.Location{}
+		for userID, loc := range pending {
+			n, _ := currentStreak(records, userID, goal, now.In(loc))
+			// 読み込んだ範囲の端まで続いていれば、もっと前から続いているかもしれない
+			if n >= days-1 && n > streaks[userID] {
+				next[userID] = loc
+			}
+			if n > 0 {
+				streaks[userID] = n
+			}
+		}
+		pending = next
+	}
+	return streaks, nil
+}
+
+// 今いるメンバーの連続記録 (記録がないメンバーは含まない)
+func (p *Pomodoro) memberStreaks() map[UserID]int {
+	if len(p.members) == 0 {
+		return nil
+	}
+
+	locs := map[UserID]*time.Location{}
+	for userID := range p.members {
+		locs[userID] = p.location
+		if prefs, ok, err := p.store.LoadUserPrefs(p.guildID, userID); err != nil {
+			log.Printf("Failed to load prefs of %s: %v", userID, err)
+		} else if ok {
+			locs[userID] = userLocation(p.location, prefs)
+		}
+	}
+	streaks, err := loadStreaks(p.store, p.guildID, p.streakGoal, p.clock.Now(), locs)
+	if err != nil {
+		log.Printf("Failed to load phases of guild (%s): %v", p.guildID, err)
+	}
+	return streaks
+}
+
+// 連続記録が今日で途切れそうなユーザーに DM を送る
+// 1 日に 1 回だけ送るように、送った日を UserPrefs に保存する
+func (b *Bot) remindStreaks(discord Discord, now time.Time) {
+	for _, guild := range b.guildInfos() {
+		prefsList, err := b.store.LoadAllUserPrefs(guild.GuildID)
+		if err != nil {
+			log.Printf("Failed to load prefs of guild (%s): %v", guild.GuildID, err)
+			continue
+		}
+		config, err := b.GetGuildConfig(guild.GuildID)
+		if err != nil {
+			log.Printf("Failed to get config of guild (%s): %v", guild.GuildID, err)
+			continue
+		}
+
+		// 今日まだ送っていない相手
+		due := []storage.UserPrefs{}
+		locs := map[UserID]*time.Location{}
+		for _, prefs := range prefsList {
+			if !prefs.StreakReminder {
+				continue
+			}
+			loc := userLocation(guild.location(), prefs)
+			local := now.In(loc)
+			if local.Hour() < StreakReminderHour || prefs.StreakRemindedOn == local.Format(streakDateLayout) {
+				continue
+			}
+			due = append(due, prefs)
+			locs[prefs.UserID] = loc
+		}
+		// 送る相手がいるときだけ読み込む
+		if len(due) == 0 {
+			continue
+		}
+		streaks, err := loadStreaks(b.store, guild.GuildID, config.StreakGoal, now, locs)
+		if err != nil {
+			log.Printf("Failed to load phases of guild (%s): %v", guild.GuildID, err)
+			continue
+		}
+		// 今日の分 (タイムゾーンの違いの分、1 日余分に読む)
+		records, err := b.store.LoadPhases(guild.GuildID, now.AddDate(0, 0, -1))
+		if err != nil {
+			log.Printf("Failed to load phases of guild (%s): %v", guild.GuildID, err)
+			continue
+		}
+
+		for _, prefs := range due {
+			local := now.In(locs[prefs.UserID])
+			today := local.Format(streakDateLayout)
+			completed := dailyCompleted(records, prefs.UserID, local.Location())[today]
+			streak := streaks[prefs.UserID]
+			if completed >= config.StreakGoal || streak == 0 {
+				continue
+			}
+
+			left := config.StreakGoal - completed
+			localizer := guild.localizer(b.bundle)
+			messageID := "Your streak ends today."
+			msg, err := localizer.Localize(&i18n.LocalizeConfig{
+				MessageID: messageID,
+				TemplateData: map[string]interface{}{
+					"Days": streak,
+					"Left": left,
+				},
+			})
+			if err != nil {
+				msg = messageID
+			}
+			if err := discord.SendDirectMessage(prefs.UserID, msg); err != nil {
+				log.Printf("Failed to send streak reminder to %s: %v", prefs.UserID, err)
+				continue
+			}
+
+			// 読み込んだ後に /pomodoro prefs や mydata delete で変わっているかもしれないので、
+			// 読み直して送った日だけを書き換える
+			latest, ok, err := b.store.LoadUserPrefs(guild.GuildID, prefs.UserID)
+			if err != nil {
+				log.Printf("Failed to load prefs of %s: %v", prefs.UserID, err)
+				continue
+			}
+			if !ok {
+				// 消されていれば作り直さない
+				continue
+			}
+			latest.StreakRemindedOn = today
+			if err := b.store.SaveUserPrefs(latest); err != nil {
+				log.Printf("Failed to save prefs of %s: %v", prefs.UserID, err)
+			}
+		}
+	}
+}
+
+// ctx が終わるまで定期的に remindStreaks を呼ぶ
+func (b *Bot) runStreakReminders(ctx context.Context, discord Discord) {
+	ticker := time.NewTicker(streakReminderInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.remindStreaks(discord, now)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package pomodoro
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pollenjp/pomodoro-bot/app/storage"
+)
+
+// 2022 年 10 月 day 日 hour 時 (UTC)
+func oct(day int, hour int) time.Time {
+	return time.Date(2022, 10, day, hour, 0, 0, 0, time.UTC)
+}
+
+// endedAt に userID が完了したタスク
+func completedTask(userID UserID, endedAt time.Time) storage.PhaseRecord {
+	return storage.PhaseRecord{
+		GuildID:      testGuildID,
+		Phase:        storage.PhaseTask,
+		EndedAt:      endedAt,
+		Duration:     25 * time.Minute,
+		Participants: []storage.Participant{{UserID: userID, Focused: 25 * time.Minute, Completed: true}},
+	}
+}
+
+func TestCurrentStreak(t *testing.T) {
+	records := []storage.PhaseRecord{
+		completedTask("alice", oct(1, 9)),
+		// 2 日は休み
+		completedTask("alice", oct(3, 9)),
+		completedTask("alice", oct(4, 9)),
+		completedTask("alice", oct(4, 10)),
+		completedTask("alice", oct(5, 9)),
+	}
+
+	for _, tt := range []struct {
+		name      string
+		goal      int
+		now       time.Time
+		streak    int
+		doneToday bool
+	}{
+		{"done today", 1, time.Date(2022, 10, 5, 12, 0, 0, 0, time.UTC), 3, true},
+		{"not yet today", 1, time.Date(2022, 10, 6, 12, 0, 0, 0, time.UTC), 3, false},
+		{"lapsed", 1, time.Date(2022, 10, 7, 12, 0, 0, 0, time.UTC), 0, false},
+		{"goal of two", 2, time.Date(2022, 10, 5, 12, 0, 0, 0, time.UTC), 1, false},
+		// UTC-10 では 5 日 9 時 (UTC) は 4 日 23 時なので、5 日はまだ終えていない
+		{"time zone", 1, time.Date(2022, 10, 5, 20, 0, 0, 0, time.UTC).In(time.FixedZone("", -10*60*60)), 3, false},
+	} {
+		streak, doneToday := currentStreak(records, "alice", tt.goal, tt.now)
+		if streak != tt.streak || doneToday != tt.doneToday {
+			t.Errorf("%s: currentStreak() = %d, %v, want %d, %v", tt.name, streak, doneToday, tt.streak, tt.doneToday)
+		}
+	}
+}
+
+func TestPomodoroShowsStreaks(t *testing.T) {
+	for mode, started := range map[PomodoroMode]string{
+		PomodoroModePomodoro: "タスク開始",
+		PomodoroModeFlowtime: "フロータイムなのん",
+	} {
+		tp := newTestPomodoro(t, "25w 5b")
+		if err := tp.SetMode(mode); err != nil {
+			t.Fatal(err)
+		}
+		tp.call(func() { tp.location = time.UTC })
+		// 10/1 9:00 に始めるので、9/29 と 9/30 の 2 日連続
+		for _, r := range []storage.PhaseRecord{
+			completedTask("alice", oct(1, 9).AddDate(0, 0, -2)),
+			completedTask("alice", oct(1, 9).AddDate(0, 0, -1)),
+		} {
+			if err := tp.store.AppendPhase(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tp.AddUser(discordgo.User{ID: "alice"}, VoiceFlags{})
+		tp.AddUser(discordgo.User{ID: "carol"}, VoiceFlags{})
+		var start string
+		for _, m := range tp.discord.Messages() {
+			if strings.Contains(m.Content, started) {
+				start = m.Content
+			}
+		}
+		if !strings.Contains(start, "<@alice> 🔥 2日連続") {
+			t.Errorf("%s: streak of alice is not shown: %q", mode, start)
+		}
+		if strings.Contains(start, "<@carol> 🔥") {
+			t.Errorf("%s: streak of carol is shown: %q", mode, start)
+		}
+	}
+}
+
+// LoadPhases に渡された since を記録する
+type sinceRecordingStore struct {
+	storage.Store
+	since []time.Time
+}
+
+func (s *sinceRecordingStore) LoadPhases(guildID string, since time.Time) ([]storage.PhaseRecord, error) {
+	s.since = append(s.since, since)
+	return s.Store.LoadPhases(guildID, since)
+}
+
+func TestLoadStreaksReadsRecentHistory(t *testing.T) {
+	store := &sinceRecordingStore{Store: storage.NewMemory()}
+	now := oct(5, 12)
+	// alice は 100 日連続、bob は 3 日連続
+	for i := 0; i < 100; i++ {
+		if err := store.AppendPhase(completedTask("alice", now.AddDate(0, 0, -i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := store.AppendPhase(completedTask("bob", now.AddDate(0, 0, -i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	streaks, err := loadStreaks(store, testGuildID, 1, now, map[UserID]*time.Location{"bob": time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streaks["bob"] != 3 {
+		t.Errorf("streak of bob = %d, want 3", streaks["bob"])
+	}
+	// 最近の分だけ読めば足りる
+	if len(store.since) != 1 || store.since[0].IsZero() {
+		t.Errorf("loaded since %v", store.since)
+	}
+
+	// 読み込んだ範囲より長く続いていれば読み直す
+	store.since = nil
+	streaks, err = loadStreaks(store, testGuildID, 1, now, map[UserID]*time.Location{"alice": time.UTC, "bob": time.UTC, "carol": time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[UserID]int{"alice": 100, "bob": 3}; !reflect.DeepEqual(streaks, want) {
+		t.Errorf("streaks = %v, want %v", streaks, want)
+	}
+	if len(store.since) < 2 {
+		t.Errorf("loaded since %v", store.since)
+	}
+}
+
+func TestRemindStreaks(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+	guild := testGuildInfo()
+	guild.TimeZone = "UTC"
+	if err := b.SetGuildInfos([]GuildInfo{guild}); err != nil {
+		t.Fatal(err)
+	}
+	discord := newFakeDiscord()
+
+	for _, r := range []storage.PhaseRecord{
+		completedTask("alice", oct(4, 9)),
+		completedTask("bob", oct(4, 9)),
+		completedTask("bob", oct(5, 9)),
+		completedTask("carol", oct(4, 9)),
+	} {
+		if err := store.AppendPhase(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, prefs := range []storage.UserPrefs{
+		// 今日はまだ
+		{GuildID: testGuildID, UserID: "alice", StreakReminder: true},
+		// 今日はもう終えた
+		{GuildID: testGuildID, UserID: "bob", StreakReminder: true},
+		// リマインダーを頼んでいない
+		{GuildID: testGuildID, UserID: "carol"},
+		// UTC-5 ではまだ 15 時
+		{GuildID: testGuildID, UserID: "dave", StreakReminder: true, TimeZone: "America/Chicago"},
+	} {
+		if err := store.SaveUserPrefs(prefs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.AppendPhase(completedTask("dave", oct(4, 9))); err != nil {
+		t.Fatal(err)
+	}
+
+	b.remindStreaks(discord, time.Date(2022, 10, 5, 19, 0, 0, 0, time.UTC))
+	if got := discord.DirectMessages("alice"); len(got) != 0 {
+		t.Errorf("reminded before the evening: %v", got)
+	}
+
+	evening := time.Date(2022, 10, 5, 20, 30, 0, 0, time.UTC)
+	b.remindStreaks(discord, evening)
+	b.remindStreaks(discord, evening.Add(streakReminderInterval))
+	if got := discord.DirectMessages("alice"); len(got) != 1 {
+		t.Errorf("alice got %d reminders, want 1: %v", len(got), got)
+	}
+	for _, userID := range []UserID{"bob", "carol", "dave"} {
+		if got := discord.DirectMessages(userID); len(got) != 0 {
+			t.Errorf("%s was reminded: %v", userID, got)
+		}
+	}
+	if prefs, _, _ := store.LoadUserPrefs(testGuildID, "alice"); prefs.StreakRemindedOn != "2022-10-05" {
+		t.Errorf("reminded on = %q", prefs.StreakRemindedOn)
+	}
+}
+
+// DM を送るときに onDirectMessage を呼ぶ
+type hookedDiscord struct {
+	*fakeDiscord
+	onDirectMessage func(userID UserID)
+}
+
+func (d hookedDiscord) SendDirectMessage(userID UserID, content string) error {
+	d.onDirectMessage(userID)
+	return d.fakeDiscord.SendDirectMessage(userID, content)
+}
+
+// DM を送っている間に変わった設定を上書きしない
+func TestRemindStreaksKeepsUpdatedPrefs(t *testing.T) {
+	b := newTestBot(t)
+	store := b.store
+	guild := testGuildInfo()
+	guild.TimeZone = "UTC"
+	if err := b.SetGuildInfos([]GuildInfo{guild}); err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []UserID{"alice", "bob"} {
+		if err := store.AppendPhase(completedTask(userID, oct(4, 9))); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveUserPrefs(storage.UserPrefs{GuildID: testGuildID, UserID: userID, StreakReminder: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	discord := hookedDiscord{fakeDiscord: newFakeDiscord(), onDirectMessage: func(userID UserID) {
+		switch userID {
+		case "alice":
+			// /pomodoro prefs で変えた
+			prefs, _, _ := store.LoadUserPrefs(testGuildID, userID)
+			prefs.Enforcement = EnforcementNone.String()
+			if err := store.SaveUserPrefs(prefs); err != nil {
+				t.Error(err)
+			}
+		case "bob":
+			// /pomodoro mydata delete で消した
+			if err := store.DeleteUserData(testGuildID, userID); err != nil {
+				t.Error(err)
+			}
+		}
+	}}
+	b.remindStreaks(discord, time.Date(2022, 10, 5, 20, 30, 0, 0, time.UTC))
+
+	prefs, _, err := store.LoadUserPrefs(testGuildID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs.Enforcement != EnforcementNone.String() || prefs.StreakRemindedOn != "2022-10-05" {
+		t.Errorf("prefs of alice = %+v", prefs)
+	}
+	if _, ok, err := store.LoadUserPrefs(testGuildID, "bob"); err != nil || ok {
+		t.Errorf("prefs of bob were saved again: %v, %v", ok, err)
+	}
+}
+
+func TestRemindStreaksReadsRecentHistory(t *testing.T) {
+	store := &sinceRecordingStore{Store: storage.NewMemory()}
+	b := newBot(store)
+	guild := testGuildInfo()
+	guild.TimeZone = "UTC"
+	guild.Locale = "en"
+	if err := b.SetGuildInfos([]GuildInfo{guild}); err != nil {
+		t.Fatal(err)
+	}
+	evening := time.Date(2022, 10, 5, 20, 30, 0, 0, time.UTC)
+	// 昨日まで 100 日連続
+	for i := 1; i <= 100; i++ {
+		if err := store.AppendPhase(completedTask("alice", evening.AddDate(0, 0, -i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveUserPrefs(storage.UserPrefs{GuildID: testGuildID, UserID: "alice", StreakReminder: true}); err != nil {
+		t.Fatal(err)
+	}
+	discord := newFakeDiscord()
+
+	b.remindStreaks(discord, evening)
+	if got := discord.DirectMessages("alice"); len(got) != 1 || !strings.Contains(got[0], "100-day") {
+		t.Errorf("alice got %v", got)
+	}
+	for _, since := range store.since {
+		if since.IsZero() {
+			t.Errorf("loaded the whole history: %v", store.since)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	guildsFileName   = "guilds.json"
+	sessionsFileName = "sessions.json"
+	historyFileName  = "history.jsonl"
+	releasesFileName = "pending_releases.json"
+	prefsFileName    = "user_prefs.json"
+)
+
+// File はデータディレクトリの下に JSON ファイルとして保存する
+//
+//	guilds.json    ギルドの設定
+//	sessions.json  動いているセッション
+//	history.jsonl  終わったフェーズの履歴 (1 行 1 件で追記する)
+//	pending_releases.json  解除できなかった mute/deafen
+//	user_prefs.json  ユーザーごとの設定
+//
+// history.jsonl 以外は書き込むたびに全体を書き直す
+// history.jsonl もユーザーのデータを消すときだけは全体を書き直す
+// 一時ファイルに書いてから rename するので、途中で落ちても壊れない
+//
+// history.jsonl は開いたときにすべて読み込み、LoadPhases ではファイルを読まない
+type File struct {
+	dir string
+
+	mu       sync.Mutex
+	guilds   map[string]GuildConfig
+	sessions map[string]Session
+	releases map[string]PendingRelease
+	prefs    map[string]UserPrefs
+	history  *os.File
+	// history.jsonl の中身 (ファイルと同じ順)
+	phases []PhaseRecord
+	// ギルドごとの phases の添字
+	guildPhases map[string][]int
+	closed      bool
+}
+
+var _ Store = (*File)(nil)
+
+// OpenFile は dir を開き、必要なマイグレーションを実行する
+// dir がなければ作る
+func OpenFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := migrate(dir); err != nil {
+		return nil, fmt.Errorf("failed to migrate %s: %w", dir, err)
+	}
+
+	f := &File{
+		dir:      dir,
+		guilds:   map[string]GuildConfig{},
+		sessions: map[string]Session{},
+		releases: map[string]PendingRelease{},
+		prefs:    map[string]UserPrefs{},
+	}
+	f.setPhases(nil)
+	if err := readJSON(filepath.Join(dir, guildsFileName), &f.guilds); err != nil {
+		return nil, err
+	}
+	if err := readJSON(filepath.Join(dir, sessionsFileName), &f.sessions); err != nil {
+		return nil, err
+	}
+	if err := readJSON(filepath.Join(dir, releasesFileName), &f.releases); err != nil {
+		return nil, err
+	}
+	if err := readJSON(filepath.Join(dir, prefsFileName), &f.prefs); err != nil {
+		return nil, err
+	}
+
+	phases, err := readHistory(filepath.Join(dir, historyFileName))
+	if err != nil {
+		return nil, err
+	}
+	f.setPhases(phases)
+
+	history, err := os.OpenFile(filepath.Join(dir, historyFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f.history = history
+
+	return f, nil
+}
+
+func (f *File) LoadGuildConfig(guildID string) (GuildConfig, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.guilds[guildID]
+	return c.copy(), ok, nil
+}
+
+func (f *File) SaveGuildConfig(guildID string, c GuildConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	f.guilds[guildID] = c.copy()
+	return writeJSON(filepath.Join(f.dir, guildsFileName), f.guilds)
+}
+
+func (f *File) LoadSessions() ([]Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedSessions(f.sessions), nil
+}
+
+func (f *File) SaveSession(s Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	f.sessions[s.VoiceChannelID] = s.copy()
+	return writeJSON(filepath.Join(f.dir, sessionsFileName), f.sessions)
+}
+
+func (f *File) DeleteSession(voiceChannelID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	if _, ok := f.sessions[voiceChannelID]; !ok {
+		return nil
+	}
+	delete(f.sessions, voiceChannelID)
+	return writeJSON(filepath.Join(f.dir, sessionsFileName), f.sessions)
+}
+
+func (f *File) LoadPendingReleases(guildID string) ([]PendingRelease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedPendingReleases(f.releases, guildID), nil
+}
+
+func (f *File) SavePendingRelease(r PendingRelease) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	if old, ok := f.releases[r.key()]; ok {
+		r = r.merge(old)
+	}
+	f.releases[r.key()] = r
+	return writeJSON(filepath.Join(f.dir, releasesFileName), f.releases)
+}
+
+func (f *File) DeletePendingRelease(guildID string, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	key := PendingRelease{GuildID: guildID, UserID: userID}.key()
+	if _, ok := f.releases[key]; !ok {
+		return nil
+	}
+	delete(f.releases, key)
+	return writeJSON(filepath.Join(f.dir, releasesFileName), f.releases)
+}
+
+func (f *File) LoadUserPrefs(guildID string, userID string) (UserPrefs, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.prefs[UserPrefs{GuildID: guildID, UserID: userID}.key()]
+	return p, ok, nil
+}
+
+func (f *File) LoadAllUserPrefs(guildID string) ([]UserPrefs, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedUserPrefs(f.prefs, guildID), nil
+}
+
+func (f *File) SaveUserPrefs(p UserPrefs) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	f.prefs[p.key()] = p
+	return writeJSON(filepath.Join(f.dir, prefsFileName), f.prefs)
+}
+
+func (f *File) AppendPhase(r PhaseRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	if _, err := f.history.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	f.addPhase(r.copy())
+	return nil
+}
+
+func (f *File) LoadPhases(guildID string, since time.Time) ([]PhaseRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	phases := []PhaseRecord{}
+	if guildID == "" {
+		for _, r := range f.phases {
+			if r.match(guildID, since) {
+				phases = append(phases, r.copy())
+			}
+		}
+		return phases, nil
+	}
+	for _, i := range f.guildPhases[guildID] {
+		if r := f.phases[i]; r.match(guildID, since) {
+			phases = append(phases, r.copy())
+		}
+	}
+	return phases, nil
+}
+
+// f.mu を取った状態で呼ぶ
+func (f *File) setPhases(phases []PhaseRecord) {
+	f.phases = nil
+	f.guildPhases = map[string][]int{}
+	for _, r := range phases {
+		f.addPhase(r)
+	}
+}
+
+// f.mu を取った状態で呼ぶ
+func (f *File) addPhase(r PhaseRecord) {
+	f.guildPhases[r.GuildID] = append(f.guildPhases[r.GuildID], len(f.phases))
+	f.phases = append(f.phases, r)
+}
+
+// history.jsonl をすべて読む (なければ空)
+func readHistory(path string) ([]PhaseRecord, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	phases := []PhaseRecord{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r PhaseRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", historyFileName, line, err)
+		}
+		phases = append(phases, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return phases, nil
+}
+
+func (f *File) DeleteUserData(guildID string, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+
+	// 書き直せたときだけ入れ替える
+	phases := append([]PhaseRecord{}, f.phases...)
+	for _, i := range f.guildPhases[guildID] {
+		phases[i] = phases[i].withoutUser(userID)
+	}
+	if err := f.rewriteHistory(phases); err != nil {
+		return err
+	}
+	f.setPhases(phases)
+
+	for id, s := range f.sessions {
+		if s.GuildID == guildID {
+			f.sessions[id] = s.withoutUser(userID)
+		}
+	}
+	if err := writeJSON(filepath.Join(f.dir, sessionsFileName), f.sessions); err != nil {
+		return err
+	}
+
+	delete(f.prefs, UserPrefs{GuildID: guildID, UserID: userID}.key())
+	return writeJSON(filepath.Join(f.dir, prefsFileName), f.prefs)
+}
+
+// history.jsonl を phases で置き換えて、追記するファイルを開き直す
+// f.mu を取った状態で呼ぶ
+func (f *File) rewriteHistory(phases []PhaseRecord) error {
+	path := filepath.Join(f.dir, historyFileName)
+	tmp, err := os.CreateTemp(f.dir, historyFileName+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, r := range phases {
+		b, err := json.Marshal(r)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if f.history != nil {
+		f.history.Close()
+	}
+	// rename に失敗しても元のファイルに追記できるように開き直す
+	renameErr := os.Rename(tmp.Name(), path)
+	history, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		f.history = nil
+		return err
+	}
+	f.history = history
+	return renameErr
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.history == nil {
+		return nil
+	}
+	err := f.history.Close()
+	f.history = nil
+	return err
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// 一時ファイルに書いてから置き換える
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
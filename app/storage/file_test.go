@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+
+	if _, ok, err := s.LoadGuildConfig("guild"); err != nil || ok {
+		t.Fatalf("LoadGuildConfig() on an empty store = %v, %v", ok, err)
+	}
+	config := GuildConfig{
+		TaskDuration: 50 * time.Minute,
+		Mode:         "pomodoro",
+		Reminders:    map[string][]time.Duration{PhaseBreak: {time.Minute, 10 * time.Second}},
+	}
+	if err := s.SaveGuildConfig("guild", config); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := s.LoadGuildConfig("guild"); err != nil || !ok || !reflect.DeepEqual(got, config) {
+		t.Errorf("LoadGuildConfig() = %+v, %v, %v", got, ok, err)
+	}
+
+	now := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+	session := Session{
+		GuildID:        "guild",
+		VoiceChannelID: "vc",
+		Phase:          PhaseTask,
+		PhaseEndAt:     now.Add(25 * time.Minute),
+		Members:        []Member{{ID: "alice", Username: "alice"}},
+	}
+	if err := s.SaveSession(session); err != nil {
+		t.Fatal(err)
+	}
+	session.Phase = PhaseBreak
+	if err := s.SaveSession(session); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.LoadSessions(); err != nil || len(got) != 1 || got[0].Phase != PhaseBreak || !got[0].PhaseEndAt.Equal(session.PhaseEndAt) {
+		t.Errorf("LoadSessions() = %+v, %v", got, err)
+	}
+	if err := s.DeleteSession("vc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteSession("vc"); err != nil {
+		t.Errorf("DeleteSession() twice: %v", err)
+	}
+	if got, err := s.LoadSessions(); err != nil || len(got) != 0 {
+		t.Errorf("LoadSessions() after delete = %+v, %v", got, err)
+	}
+
+	for _, r := range []PendingRelease{{GuildID: "guild", UserID: "bob"}, {GuildID: "other", UserID: "bob"}, {GuildID: "guild", UserID: "alice"}} {
+		if err := s.SavePendingRelease(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeletePendingRelease("other", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeletePendingRelease("other", "bob"); err != nil {
+		t.Errorf("DeletePendingRelease() twice: %v", err)
+	}
+	if got, err := s.LoadPendingReleases("guild"); err != nil || len(got) != 2 || got[0].UserID != "alice" {
+		t.Errorf("LoadPendingReleases(guild) = %+v, %v", got, err)
+	}
+	if got, err := s.LoadPendingReleases("other"); err != nil || len(got) != 0 {
+		t.Errorf("LoadPendingReleases(other) = %+v, %v", got, err)
+	}
+	// 前に保存した解除は残す
+	for _, r := range []PendingRelease{{GuildID: "other", UserID: "carol", KeepMute: true}, {GuildID: "other", UserID: "carol", KeepDeaf: true}} {
+		if err := s.SavePendingRelease(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := s.LoadPendingReleases("other"); err != nil || len(got) != 1 || got[0].KeepMute || got[0].KeepDeaf {
+		t.Errorf("LoadPendingReleases(other) after merging = %+v, %v", got, err)
+	}
+	if err := s.DeletePendingRelease("other", "carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := s.LoadUserPrefs("guild", "alice"); err != nil || ok {
+		t.Errorf("LoadUserPrefs() on an empty store = %v, %v", ok, err)
+	}
+	prefs := UserPrefs{GuildID: "guild", UserID: "alice", Enforcement: "mute"}
+	if err := s.SaveUserPrefs(prefs); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := s.LoadUserPrefs("guild", "alice"); err != nil || !ok || got != prefs {
+		t.Errorf("LoadUserPrefs() = %+v, %v, %v", got, ok, err)
+	}
+	if _, ok, _ := s.LoadUserPrefs("other", "alice"); ok {
+		t.Error("prefs of another guild were loaded")
+	}
+	if err := s.SaveUserPrefs(UserPrefs{GuildID: "other", UserID: "bob", TimeZone: "UTC", StreakReminder: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.LoadAllUserPrefs("guild"); err != nil || len(got) != 1 || got[0] != prefs {
+		t.Errorf("LoadAllUserPrefs(guild) = %+v, %v", got, err)
+	}
+	if got, err := s.LoadAllUserPrefs(""); err != nil || len(got) != 2 {
+		t.Errorf("LoadAllUserPrefs() = %+v, %v", got, err)
+	}
+
+	for i, guildID := range []string{"guild", "other", "guild"} {
+		if err := s.AppendPhase(PhaseRecord{
+			GuildID:   guildID,
+			Phase:     PhaseTask,
+			StartedAt: now.Add(time.Duration(i) * time.Hour),
+			EndedAt:   now.Add(time.Duration(i)*time.Hour + 25*time.Minute),
+			Duration:  25 * time.Minute,
+			Members:   []string{"alice"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := s.LoadPhases("", time.Time{}); err != nil || len(got) != 3 {
+		t.Errorf("LoadPhases(all) = %d records, %v", len(got), err)
+	}
+	if got, err := s.LoadPhases("guild", time.Time{}); err != nil || len(got) != 2 {
+		t.Errorf("LoadPhases(guild) = %d records, %v", len(got), err)
+	}
+	if got, err := s.LoadPhases("guild", now.Add(time.Hour)); err != nil || len(got) != 1 {
+		t.Errorf("LoadPhases(guild, since) = %d records, %v", len(got), err)
+	}
+}
+
+// 他のユーザーやギルドのデータは残す
+func testDeleteUserData(t *testing.T, s Store) {
+	t.Helper()
+	now := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+	for _, r := range []PhaseRecord{
+		{GuildID: "guild", Phase: PhaseTask, EndedAt: now, Members: []string{"alice", "bob"}, Participants: []Participant{{UserID: "alice", Completed: true}, {UserID: "bob", Completed: true}}},
+		{GuildID: "other", Phase: PhaseTask, EndedAt: now, Members: []string{"alice"}, Participants: []Participant{{UserID: "alice", Completed: true}}},
+	} {
+		if err := s.AppendPhase(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveSession(Session{GuildID: "guild", VoiceChannelID: "vc", Members: []Member{{ID: "alice"}, {ID: "bob"}}, LeftParticipants: []Participant{{UserID: "alice", Left: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []UserPrefs{{GuildID: "guild", UserID: "alice", TimeZone: "UTC"}, {GuildID: "other", UserID: "alice", TimeZone: "UTC"}} {
+		if err := s.SaveUserPrefs(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SavePendingRelease(PendingRelease{GuildID: "guild", UserID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteUserData("guild", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	phases, err := s.LoadPhases("", time.Time{})
+	if err != nil || len(phases) != 2 {
+		t.Fatalf("LoadPhases() = %+v, %v", phases, err)
+	}
+	if got := phases[0]; len(got.Members) != 1 || got.Members[0] != "bob" || len(got.Participants) != 1 || got.Participants[0].UserID != "bob" {
+		t.Errorf("alice was not removed from history: %+v", got)
+	}
+	if got := phases[1]; len(got.Participants) != 1 {
+		t.Errorf("history of another guild was changed: %+v", got)
+	}
+	sessions, _ := s.LoadSessions()
+	if len(sessions) != 1 || len(sessions[0].Members) != 1 || sessions[0].Members[0].ID != "bob" || len(sessions[0].LeftParticipants) != 0 {
+		t.Errorf("alice was not removed from the session: %+v", sessions)
+	}
+	if _, ok, _ := s.LoadUserPrefs("guild", "alice"); ok {
+		t.Error("prefs were not deleted")
+	}
+	if _, ok, _ := s.LoadUserPrefs("other", "alice"); !ok {
+		t.Error("prefs of another guild were deleted")
+	}
+	if got, _ := s.LoadPendingReleases("guild"); len(got) != 1 {
+		t.Errorf("pending release was deleted: %+v", got)
+	}
+
+	// 消した後も追記できる
+	if err := s.AppendPhase(PhaseRecord{GuildID: "guild", Phase: PhaseBreak, EndedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.LoadPhases("", time.Time{}); err != nil || len(got) != 3 {
+		t.Errorf("LoadPhases() after append = %d records, %v", len(got), err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+	testDeleteUserData(t, NewMemory())
+}
+
+func TestFileDeleteUserData(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDeleteUserData(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	phases, err := s.LoadPhases("guild", time.Time{})
+	if err != nil || len(phases) != 2 || len(phases[0].Participants) != 1 {
+		t.Errorf("LoadPhases() after reopen = %+v, %v", phases, err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	s, err := OpenFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	if err := s.SaveSession(Session{GuildID: "guild", VoiceChannelID: "vc2", Phase: PhaseTask}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 開き直しても残っている
+	s, err = OpenFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok, err := s.LoadGuildConfig("guild"); err != nil || !ok {
+		t.Errorf("guild config was lost: %v, %v", ok, err)
+	}
+	if got, err := s.LoadSessions(); err != nil || len(got) != 1 || got[0].VoiceChannelID != "vc2" {
+		t.Errorf("LoadSessions() after reopen = %+v, %v", got, err)
+	}
+	if got, err := s.LoadPendingReleases(""); err != nil || len(got) != 2 {
+		t.Errorf("LoadPendingReleases() after reopen = %+v, %v", got, err)
+	}
+	if _, ok, err := s.LoadUserPrefs("guild", "alice"); err != nil || !ok {
+		t.Errorf("user prefs were lost: %v, %v", ok, err)
+	}
+	if got, err := s.LoadPhases("", time.Time{}); err != nil || len(got) != 3 {
+		t.Errorf("LoadPhases() after reopen = %d records, %v", len(got), err)
+	}
+}
+
+// 履歴は開いたときに読み込み、LoadPhases のたびにファイルを読まない
+func TestFileIndexesHistory(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+	for _, r := range []PhaseRecord{
+		{GuildID: "guild", Phase: PhaseTask, EndedAt: now.Add(-2 * time.Hour)},
+		{GuildID: "other", Phase: PhaseTask, EndedAt: now},
+		{GuildID: "guild", Phase: PhaseBreak, EndedAt: now},
+	} {
+		if err := s.AppendPhase(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.AppendPhase(PhaseRecord{GuildID: "guild", Phase: PhaseTask, EndedAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	// ファイルが読めなくなっても読み込んだ分から返す
+	if err := os.Remove(filepath.Join(dir, historyFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	phases, err := s.LoadPhases("guild", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(phases) != 2 || phases[0].Phase != PhaseBreak || phases[1].Phase != PhaseTask {
+		t.Errorf("LoadPhases(guild, now) = %+v", phases)
+	}
+	if phases, err := s.LoadPhases("", time.Time{}); err != nil || len(phases) != 4 || phases[1].GuildID != "other" {
+		t.Errorf("LoadPhases() = %+v, %v", phases, err)
+	}
+
+	// 返したものを書き換えても中身は変わらない
+	phases[0].Participants = append(phases[0].Participants, Participant{UserID: "mallory"})
+	if again, _ := s.LoadPhases("guild", now); len(again[0].Participants) != 0 {
+		t.Errorf("index was modified: %+v", again[0])
+	}
+}
+
+// Close の後の書き込みは捨てるが、読み込みはできる
+func testWritesAfterClose(t *testing.T, s Store) {
+	t.Helper()
+	if err := s.SaveSession(Session{GuildID: "guild", VoiceChannelID: "vc", Phase: PhaseTask}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, write := range map[string]func() error{
+		"SaveGuildConfig":      func() error { return s.SaveGuildConfig("guild", GuildConfig{}) },
+		"SaveSession":          func() error { return s.SaveSession(Session{GuildID: "guild", VoiceChannelID: "vc2"}) },
+		"DeleteSession":        func() error { return s.DeleteSession("vc") },
+		"SavePendingRelease":   func() error { return s.SavePendingRelease(PendingRelease{GuildID: "guild", UserID: "alice"}) },
+		"DeletePendingRelease": func() error { return s.DeletePendingRelease("guild", "alice") },
+		"SaveUserPrefs":        func() error { return s.SaveUserPrefs(UserPrefs{GuildID: "guild", UserID: "alice"}) },
+		"AppendPhase":          func() error { return s.AppendPhase(PhaseRecord{GuildID: "guild", Phase: PhaseTask}) },
+		"DeleteUserData":       func() error { return s.DeleteUserData("guild", "alice") },
+	} {
+		if err := write(); err != ErrClosed {
+			t.Errorf("%s after Close() = %v, want ErrClosed", name, err)
+		}
+	}
+
+	if sessions, err := s.LoadSessions(); err != nil || len(sessions) != 1 || sessions[0].VoiceChannelID != "vc" {
+		t.Errorf("LoadSessions() after Close() = %+v, %v", sessions, err)
+	}
+	if phases, err := s.LoadPhases("", time.Time{}); err != nil || len(phases) != 0 {
+		t.Errorf("LoadPhases() after Close() = %+v, %v", phases, err)
+	}
+}
+
+func TestWritesAfterClose(t *testing.T) {
+	testWritesAfterClose(t, NewMemory())
+
+	dir := t.TempDir()
+	s, err := OpenFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWritesAfterClose(t, s)
+
+	// ファイルにも書かれていない
+	s, err = OpenFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if sessions, _ := s.LoadSessions(); len(sessions) != 1 {
+		t.Errorf("LoadSessions() after reopen = %+v", sessions)
+	}
+	if phases, _ := s.LoadPhases("", time.Time{}); len(phases) != 0 {
+		t.Errorf("LoadPhases() after reopen = %+v", phases)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	if err := migrate(dir); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := readSchemaVersion(dir); err != nil || v != latestSchemaVersion() {
+		t.Errorf("schema version = %d, %v, want %d", v, err, latestSchemaVersion())
+	}
+	for _, name := range []string{guildsFileName, sessionsFileName, historyFileName, releasesFileName, prefsFileName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was not created: %v", name, err)
+		}
+	}
+
+	// 2 回目は何もしない
+	if err := migrate(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新しいバージョンのデータは開かない
+	if err := writeSchemaVersion(dir, latestSchemaVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(dir); err == nil {
+		t.Error("OpenFile() with a newer schema did not fail")
+	}
+}
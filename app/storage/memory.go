@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory はプロセスのメモリ上にだけ保存する
+// データディレクトリを指定しないときやテストで使う
+type Memory struct {
+	mu       sync.Mutex
+	guilds   map[string]GuildConfig
+	sessions map[string]Session
+	releases map[string]PendingRelease
+	prefs    map[string]UserPrefs
+	phases   []PhaseRecord
+	closed   bool
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		guilds:   map[string]GuildConfig{},
+		sessions: map[string]Session{},
+		releases: map[string]PendingRelease{},
+		prefs:    map[string]UserPrefs{},
+	}
+}
+
+func (m *Memory) LoadGuildConfig(guildID string) (GuildConfig, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.guilds[guildID]
+	return c.copy(), ok, nil
+}
+
+func (m *Memory) SaveGuildConfig(guildID string, c GuildConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.guilds[guildID] = c.copy()
+	return nil
+}
+
+func (m *Memory) LoadSessions() ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedSessions(m.sessions), nil
+}
+
+func (m *Memory) SaveSession(s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.sessions[s.VoiceChannelID] = s.copy()
+	return nil
+}
+
+func (m *Memory) DeleteSession(voiceChannelID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	delete(m.sessions, voiceChannelID)
+	return nil
+}
+
+func (m *Memory) LoadPendingReleases(guildID string) ([]PendingRelease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedPendingReleases(m.releases, guildID), nil
+}
+
+func (m *Memory) SavePendingRelease(r PendingRelease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if old, ok := m.releases[r.key()]; ok {
+		r = r.merge(old)
+	}
+	m.releases[r.key()] = r
+	return nil
+}
+
+func (m *Memory) DeletePendingRelease(guildID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	delete(m.releases, PendingRelease{GuildID: guildID, UserID: userID}.key())
+	return nil
+}
+
+func (m *Memory) LoadUserPrefs(guildID string, userID string) (UserPrefs, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.prefs[UserPrefs{GuildID: guildID, UserID: userID}.key()]
+	return p, ok, nil
+}
+
+func (m *Memory) LoadAllUserPrefs(guildID string) ([]UserPrefs, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedUserPrefs(m.prefs, guildID), nil
+}
+
+func (m *Memory) SaveUserPrefs(p UserPrefs) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.prefs[p.key()] = p
+	return nil
+}
+
+func (m *Memory) AppendPhase(r PhaseRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.phases = append(m.phases, r.copy())
+	return nil
+}
+
+func (m *Memory) LoadPhases(guildID string, since time.Time) ([]PhaseRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	phases := []PhaseRecord{}
+	for _, r := range m.phases {
+		if r.match(guildID, since) {
+			phases = append(phases, r.copy())
+		}
+	}
+	return phases, nil
+}
+
+func (m *Memory) DeleteUserData(guildID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	for i, r := range m.phases {
+		if r.GuildID == guildID {
+			m.phases[i] = r.withoutUser(userID)
+		}
+	}
+	for id, s := range m.sessions {
+		if s.GuildID == guildID {
+			m.sessions[id] = s.withoutUser(userID)
+		}
+	}
+	delete(m.prefs, UserPrefs{GuildID: guildID, UserID: userID}.key())
+	return nil
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (c GuildConfig) copy() GuildConfig {
+	if c.Reminders != nil {
+		reminders := map[string][]time.Duration{}
+		for kind, offsets := range c.Reminders {
+			reminders[kind] = append([]time.Duration{}, offsets...)
+		}
+		c.Reminders = reminders
+	}
+	return c
+}
+
+func (s Session) copy() Session {
+	s.Members = append([]Member{}, s.Members...)
+	if s.LeftParticipants != nil {
+		s.LeftParticipants = append([]Participant{}, s.LeftParticipants...)
+	}
+	return s
+}
+
+// 前に保存した old で解除するものも解除する
+func (r PendingRelease) merge(old PendingRelease) PendingRelease {
+	r.KeepMute = r.KeepMute && old.KeepMute
+	r.KeepDeaf = r.KeepDeaf && old.KeepDeaf
+	return r
+}
+
+func (r PhaseRecord) copy() PhaseRecord {
+	r.Members = append([]string{}, r.Members...)
+	if r.Participants != nil {
+		r.Participants = append([]Participant{}, r.Participants...)
+	}
+	return r
+}
+
+func (s Session) withoutUser(userID string) Session {
+	members := []Member{}
+	for _, m := range s.Members {
+		if m.ID != userID {
+			members = append(members, m)
+		}
+	}
+	s.Members = members
+	s.LeftParticipants = participantsWithout(s.LeftParticipants, userID)
+	return s
+}
+
+func (r PhaseRecord) withoutUser(userID string) PhaseRecord {
+	members := []string{}
+	for _, id := range r.Members {
+		if id != userID {
+			members = append(members, id)
+		}
+	}
+	r.Members = members
+	r.Participants = participantsWithout(r.Participants, userID)
+	return r
+}
+
+// 空になれば nil を返す
+func participantsWithout(participants []Participant, userID string) []Participant {
+	var list []Participant
+	for _, p := range participants {
+		if p.UserID != userID {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func (r PhaseRecord) match(guildID string, since time.Time) bool {
+	if guildID != "" && r.GuildID != guildID {
+		return false
+	}
+	if !since.IsZero() && r.EndedAt.Before(since) {
+		return false
+	}
+	return true
+}
+
+// VC の ID 順に並べる
+func sortedSessions(sessions map[string]Session) []Session {
+	list := []Session{}
+	for _, s := range sessions {
+		list = append(list, s.copy())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].VoiceChannelID < list[j].VoiceChannelID
+	})
+	return list
+}
+
+// ギルドとユーザーの ID 順に並べる
+func sortedPendingReleases(releases map[string]PendingRelease, guildID string) []PendingRelease {
+	list := []PendingRelease{}
+	for _, r := range releases {
+		if guildID == "" || r.GuildID == guildID {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key() < list[j].key()
+	})
+	return list
+}
+
+// ギルドとユーザーの ID 順に並べる
+func sortedUserPrefs(prefs map[string]UserPrefs, guildID string) []UserPrefs {
+	list := []UserPrefs{}
+	for _, p := range prefs {
+		if guildID == "" || p.GuildID == guildID {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key() < list[j].key()
+	})
+	return list
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const schemaVersionFileName = "schema_version"
+
+type migration struct {
+	version     int
+	description string
+	up          func(dir string) error
+}
+
+// データディレクトリの形式を変えるときは末尾に追加する
+// 一度リリースしたマイグレーションは書き換えない
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create guilds, sessions and history files",
+		up: func(dir string) error {
+			for _, name := range []string{guildsFileName, sessionsFileName} {
+				if err := createFileIfNotExist(filepath.Join(dir, name), []byte("{}")); err != nil {
+					return err
+				}
+			}
+			return createFileIfNotExist(filepath.Join(dir, historyFileName), nil)
+		},
+	},
+	{
+		version:     2,
+		description: "create pending releases file",
+		up: func(dir string) error {
+			return createFileIfNotExist(filepath.Join(dir, releasesFileName), []byte("{}"))
+		},
+	},
+	{
+		version:     3,
+		description: "create user prefs file",
+		up: func(dir string) error {
+			return createFileIfNotExist(filepath.Join(dir, prefsFileName), []byte("{}"))
+		},
+	},
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// dir のスキーマのバージョンを読み、足りないマイグレーションを順に実行する
+func migrate(dir string) error {
+	current, err := readSchemaVersion(dir)
+	if err != nil {
+		return err
+	}
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("schema version %d is newer than this bot supports (%d)", current, latestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("Migrating %s to version %d: %s", dir, m.version, m.description)
+		if err := m.up(dir); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		if err := writeSchemaVersion(dir, m.version); err != nil {
+			return err
+		}
+		current = m.version
+	}
+	return nil
+}
+
+// バージョンのファイルがなければ 0 (空のディレクトリ)
+func readSchemaVersion(dir string) (int, error) {
+	b, err := os.ReadFile(filepath.Join(dir, schemaVersionFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", schemaVersionFileName, err)
+	}
+	return v, nil
+}
+
+func writeSchemaVersion(dir string, version int) error {
+	return os.WriteFile(filepath.Join(dir, schemaVersionFileName), []byte(strconv.Itoa(version)+"\n"), 0o644)
+}
+
+func createFileIfNotExist(path string, content []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
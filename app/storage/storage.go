@@ -0,0 +1,189 @@
+// Package storage は bot の状態をプロセスの外に保存する
+//
+// ギルドの設定、動いているセッションの状態、終わったフェーズの履歴を扱う
+// pomodoro パッケージに依存しないように、ここでは素朴な型だけを使う
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// Close した後に書き込もうとすると返す
+var ErrClosed = errors.New("storage is closed")
+
+// Store は永続化の実装
+// 複数の goroutine から同時に呼んでもよい
+type Store interface {
+	// 保存されていなければ false を返す
+	LoadGuildConfig(guildID string) (GuildConfig, bool, error)
+	SaveGuildConfig(guildID string, c GuildConfig) error
+
+	LoadSessions() ([]Session, error)
+	// 同じ VoiceChannelID のセッションは上書きする
+	SaveSession(s Session) error
+	// 保存されていなくてもエラーにしない
+	DeleteSession(voiceChannelID string) error
+
+	// guildID が空なら全ギルド
+	LoadPendingReleases(guildID string) ([]PendingRelease, error)
+	// 同じギルドとユーザーのものがあれば、解除するものを合わせる
+	SavePendingRelease(r PendingRelease) error
+	// 保存されていなくてもエラーにしない
+	DeletePendingRelease(guildID string, userID string) error
+
+	// 保存されていなければ false を返す
+	LoadUserPrefs(guildID string, userID string) (UserPrefs, bool, error)
+	// guildID が空なら全ギルド
+	LoadAllUserPrefs(guildID string) ([]UserPrefs, error)
+	SaveUserPrefs(p UserPrefs) error
+
+	AppendPhase(r PhaseRecord) error
+	// guildID が空なら全ギルド、since がゼロなら全期間
+	LoadPhases(guildID string, since time.Time) ([]PhaseRecord, error)
+
+	// ユーザーを履歴と保存したセッションから取り除き、設定を消す
+	// 解除できていない mute/deafen を後で解除するために PendingRelease は残す
+	DeleteUserData(guildID string, userID string) error
+
+	// Close の後の書き込みは何もせずに ErrClosed を返す (読み込みはできる)
+	// 止まりきっていない goroutine が後から書き込んでも壊れないようにする
+	Close() error
+}
+
+// フェーズの種類
+const (
+	PhaseTask      = "task"
+	PhaseBreak     = "break"
+	PhaseLongBreak = "long_break"
+)
+
+type GuildConfig struct {
+	TaskDuration      time.Duration `json:"task_duration"`
+	BreakDuration     time.Duration `json:"break_duration"`
+	LongBreakDuration time.Duration `json:"long_break_duration"`
+	LongBreakInterval int           `json:"long_break_interval"`
+	Cycles            int           `json:"cycles"`
+	// "pomodoro" or "flowtime"
+	Mode string `json:"mode"`
+	// "none", "mute", "deafen" or "mute+deafen" (空なら "mute+deafen")
+	Enforcement        string  `json:"enforcement,omitempty"`
+	FlowtimeBreakRatio float64 `json:"flowtime_break_ratio"`
+	Schedule           string  `json:"schedule"`
+	// 連続記録に必要な 1 日のポモドーロの数 (0 なら 1)
+	StreakGoal int `json:"streak_goal,omitempty"`
+	// フェーズの種類ごとのリマインダー
+	Reminders map[string][]time.Duration `json:"reminders"`
+}
+
+type Member struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	// 参加する前から付いていた server mute/deafen
+	KeepMute bool `json:"keep_mute,omitempty"`
+	KeepDeaf bool `json:"keep_deaf,omitempty"`
+	// bot が付けている server mute/deafen
+	Muted    bool `json:"muted,omitempty"`
+	Deafened bool `json:"deafened,omitempty"`
+	// 現在のフェーズに参加した時点のフェーズの経過時間 (最初からいれば 0)
+	JoinedActive time.Duration `json:"joined_active,omitempty"`
+}
+
+// Session は動いているポモドーロの状態
+type Session struct {
+	GuildID        string `json:"guild_id"`
+	VoiceChannelID string `json:"voice_channel_id"`
+	TextChannelID  string `json:"text_channel_id"`
+
+	// 現在のフェーズの種類
+	Phase    string `json:"phase"`
+	Mode     string `json:"mode"`
+	Schedule string `json:"schedule"`
+	Cycles   int    `json:"cycles"`
+
+	PhaseIndex          int `json:"phase_index"`
+	Round               int `json:"round"`
+	CompletedTasks      int `json:"completed_tasks"`
+	TasksSinceLongBreak int `json:"tasks_since_long_break"`
+	CompletedBreaks     int `json:"completed_breaks"`
+
+	PhaseStartedAt time.Time `json:"phase_started_at"`
+	PhaseEndAt     time.Time `json:"phase_end_at"`
+	Paused         bool      `json:"paused"`
+	PausedAt       time.Time `json:"paused_at"`
+	// 現在のフェーズで一時停止していた時間
+	PausedFor time.Duration `json:"paused_for"`
+	// 一時停止中の残り時間
+	Remaining time.Duration `json:"remaining"`
+	// flowtime mode で最後に集中し始めた時刻と、それまでに集中した時間
+	FocusStartedAt     time.Time     `json:"focus_started_at"`
+	FocusedBeforePause time.Duration `json:"focused_before_pause"`
+
+	Members []Member `json:"members"`
+	// 現在のフェーズの途中で抜けたメンバー
+	LeftParticipants []Participant `json:"left_participants,omitempty"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+}
+
+// PhaseRecord は終わったフェーズの記録
+type PhaseRecord struct {
+	GuildID        string    `json:"guild_id"`
+	VoiceChannelID string    `json:"voice_channel_id"`
+	Phase          string    `json:"phase"`
+	StartedAt      time.Time `json:"started_at"`
+	EndedAt        time.Time `json:"ended_at"`
+	// 一時停止していた時間を除いた長さ
+	Duration time.Duration `json:"duration"`
+	// skip で終えた場合は true
+	Skipped bool `json:"skipped"`
+	// 途中で停止した場合は true
+	Stopped bool `json:"stopped,omitempty"`
+	// フェーズが終わったときにいたメンバー
+	Members []string `json:"members"`
+	// 途中で抜けた人を含む、フェーズに参加したメンバー
+	// 古い記録にはない
+	Participants []Participant `json:"participants,omitempty"`
+}
+
+// Participant はメンバーごとのフェーズへの参加の記録
+type Participant struct {
+	UserID string `json:"user_id"`
+	// 一時停止していた時間を除いて参加していた時間
+	Focused time.Duration `json:"focused"`
+	// フェーズの最初から最後までいて、フェーズが最後まで進んだ
+	Completed bool `json:"completed,omitempty"`
+	// フェーズの途中で抜けた
+	Left bool `json:"left,omitempty"`
+}
+
+// PendingRelease は解除できなかった server mute/deafen
+// VC にいないユーザーは解除できないので、次に VC に入ったときに解除する
+type PendingRelease struct {
+	GuildID string `json:"guild_id"`
+	UserID  string `json:"user_id"`
+	// bot が付けたものではないので解除しない
+	KeepMute bool `json:"keep_mute,omitempty"`
+	KeepDeaf bool `json:"keep_deaf,omitempty"`
+}
+
+func (r PendingRelease) key() string {
+	return r.GuildID + "/" + r.UserID
+}
+
+// UserPrefs はギルドごとのユーザーの設定
+type UserPrefs struct {
+	GuildID string `json:"guild_id"`
+	UserID  string `json:"user_id"`
+	// 空ならルームやギルドの設定に従う
+	Enforcement string `json:"enforcement,omitempty"`
+	// 連続記録を数えるタイムゾーン (空ならギルドのタイムゾーン)
+	TimeZone string `json:"time_zone,omitempty"`
+	// 連続記録が途切れそうな日の夜に DM を送る
+	StreakReminder bool `json:"streak_reminder,omitempty"`
+	// 最後に DM を送った日 (TimeZone での "2006-01-02")
+	StreakRemindedOn string `json:"streak_reminded_on,omitempty"`
+}
+
+func (p UserPrefs) key() string {
+	return p.GuildID + "/" + p.UserID
+}
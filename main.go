@@ -1,30 +1,89 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/pollenjp/pomodoro-bot/app"
-	_ "github.com/pollenjp/pomodoro-bot/app/pomodoro"
+	"github.com/pollenjp/pomodoro-bot/app/pomodoro"
 )
 
+// 終了するときにポモドーロを止めるのを待つ時間
+const shutdownTimeout = 10 * time.Second
+
 func init() {
 	setTimezone()
 }
 
 func main() {
-	defer app.Destructor.Close()
+	guilds, err := loadGuildSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, g := range guilds {
+		fmt.Printf("Guild: %+v\n", g.GuildInfo)
+	}
+
+	config := pomodoro.BotConfig{
+		Token:   os.Getenv("DISCORD_TOKEN"),
+		Guilds:  guilds,
+		DataDir: os.Getenv("DATA_DIR"),
+	}
+
+	bot, err := pomodoro.NewBot(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+	if err := bot.Open(ctx); err != nil {
+		log.Fatal(err)
+	}
 
 	log.Print("booted!!!")
 
-	<-sc
+	<-ctx.Done()
+	// もう一度シグナルを送れば終了処理を待たずに止められる
+	stop()
+
+	log.Print("shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := bot.Shutdown(shutdownCtx); err != nil {
+		log.Print(err)
+	}
+}
+
+// GUILDS_FILE があればそこから複数ギルドの設定を読み込む
+// なければ GUILD_ID などの環境変数から 1 つのギルドを設定する
+func loadGuildSettings() ([]pomodoro.GuildSettings, error) {
+	if path := os.Getenv("GUILDS_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return pomodoro.LoadGuildSettings(f)
+	}
 
+	return []pomodoro.GuildSettings{
+		{
+			GuildInfo: pomodoro.GuildInfo{
+				GuildID:                  os.Getenv("GUILD_ID"),
+				ChannelIDForNotification: os.Getenv("CHANNEL_ID_FOR_NOTIFICATION"),
+				Rooms: []pomodoro.RoomInfo{
+					{VoiceChannelID: os.Getenv("CHANNEL_ID_FOR_POMODORO_VC")},
+				},
+				Locale: os.Getenv("LOCALE"),
+			},
+		},
+	}, nil
 }
 
 func setTimezone() {